@@ -0,0 +1,102 @@
+package jpkg
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	jpkg_impl "github.com/j4d3blooded/JPkg/impl"
+)
+
+type JPkgEncodeStats struct {
+	Files            []JPkgFileStats
+	UncompressedSize uint64
+	// size of the record data as written, including chunk tables and encryption overhead
+	StoredSize uint64
+}
+
+type JPkgFileStats struct {
+	Path             string
+	CompressionFlag  jpkg_impl.CompressionFlag
+	UncompressedSize uint64
+	// for files in a solid block, the stored size of the whole block
+	StoredSize uint64
+	Solid      bool
+}
+
+// fraction of the uncompressed size saved, negative if the stored data is larger
+func (s JPkgEncodeStats) Savings() float64 {
+	if s.UncompressedSize == 0 {
+		return 0
+	}
+	return 1 - float64(s.StoredSize)/float64(s.UncompressedSize)
+}
+
+// the size and chosen compression of every file written by Encode
+func (j *JPkgEncoder) Stats() JPkgEncodeStats {
+	stats := JPkgEncodeStats{}
+	blocks := map[uint64]bool{}
+
+	for _, entry := range j.directory {
+		stats.Files = append(stats.Files, JPkgFileStats{
+			Path:             entry.FilePath,
+			CompressionFlag:  entry.CompressionFlag,
+			UncompressedSize: entry.UncompressedDataSize,
+			StoredSize:       entry.CompressedDataSize,
+			Solid:            entry.block != nil,
+		})
+		stats.UncompressedSize += entry.UncompressedDataSize
+
+		if entry.block != nil { // blocks are only counted once
+			if blocks[entry.Offset] {
+				continue
+			}
+			blocks[entry.Offset] = true
+		}
+		stats.StoredSize += entry.CompressedDataSize
+	}
+
+	return stats
+}
+
+// compresses the start of source with every candidate, returning the one with the smallest
+// output, and a reader that still starts at the beginning of source
+func (j *JPkgEncoder) chooseCompression(source io.Reader) (jpkg_impl.CompressionHandler, io.Reader, error) {
+	sampleSize := j.AdaptiveSampleSize
+	if sampleSize == 0 {
+		sampleSize = j.ChunkSize
+	}
+
+	sample := make([]byte, sampleSize)
+	n, err := io.ReadFull(source, sample)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, nil, fmt.Errorf("error reading sample: %w", err)
+	}
+	sample = sample[:n]
+
+	source = io.MultiReader(bytes.NewReader(sample), source)
+
+	var best jpkg_impl.CompressionHandler = &jpkg_impl.NullCompressionHandler{}
+	bestSize := len(sample)
+
+	candidates := append([]jpkg_impl.CompressionHandler{j.Compression}, j.CompressionCandidates...)
+	compressed := bytes.Buffer{}
+
+	for _, candidate := range candidates {
+		candidate, err := j.withDictionary(candidate)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		compressed.Reset()
+		if err := compressChunk(candidate, &compressed, sample); err != nil {
+			return nil, nil, fmt.Errorf("error compressing sample with %v: %w", candidate.Flag(), err)
+		}
+
+		if compressed.Len() < bestSize {
+			best, bestSize = candidate, compressed.Len()
+		}
+	}
+
+	return best, source, nil
+}
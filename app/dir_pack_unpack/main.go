@@ -14,16 +14,34 @@ import (
 )
 
 var (
-	MODE      string
-	PACKAGE   string
-	DIRECTORY string
-	VALID     bool
+	MODE       string
+	PACKAGE    string
+	DIRECTORY  string
+	PASSPHRASE string
+	HIDE_INDEX bool
+	COMPRESSOR string
+	LEVEL      int
+	DICTIONARY int
+	ADAPTIVE   bool
+	SOLID      uint64
+	TRAIN_DICT uint64
+	WORKERS    int
+	VALID      bool
 )
 
 func init() {
 	flag.StringVar(&MODE, "mode", "?", "Package mode (Pack, Unpack, Query)")
 	flag.StringVar(&PACKAGE, "package", ".", "Package to unpack / output too")
 	flag.StringVar(&DIRECTORY, "directory", "package.jpkg", "Directory to pack / output too")
+	flag.StringVar(&PASSPHRASE, "passphrase", "", "Passphrase to encrypt / decrypt the package with")
+	flag.BoolVar(&HIDE_INDEX, "encrypt-index", false, "Also encrypt file names and metadata when packing with a passphrase")
+	flag.StringVar(&COMPRESSOR, "compression", "lzw", "Compression to pack with (None, LZW, Deflate, Zlib, Gzip, Zstd, LZ4, XZ), comma separated candidates with -adaptive")
+	flag.IntVar(&LEVEL, "level", 0, "Compression level or XZ preset, 0 uses the compression's default")
+	flag.IntVar(&DICTIONARY, "dictionary", 0, "XZ dictionary size in bytes, 0 uses the preset's size")
+	flag.BoolVar(&ADAPTIVE, "adaptive", false, "Pick the best compression for each file, storing files that don't compress")
+	flag.Uint64Var(&SOLID, "solid", 0, "Pack files no larger than this many bytes into shared blocks of this size")
+	flag.Uint64Var(&TRAIN_DICT, "train-dictionary", 0, "Train a shared dictionary of about this many bytes from the files (Deflate, Zlib, Zstd)")
+	flag.IntVar(&WORKERS, "workers", 0, "Goroutines compressing and encrypting while packing, 0 uses one per CPU")
 	flag.Parse()
 	MODE = strings.ToLower(MODE)
 }
@@ -49,7 +67,24 @@ func pack() {
 
 	p := jpkg.NewJPkgEncoder(f)
 	p.Name = "Archive"
-	p.Compression = &jpkg_impl.LZWCompressionHandler{}
+	compressors := strings.Split(COMPRESSOR, ",")
+	if len(compressors) > 1 && !ADAPTIVE {
+		panic(fmt.Errorf("multiple compressions need -adaptive"))
+	}
+
+	p.Compression = compressionHandler(compressors[0])
+	for _, name := range compressors[1:] {
+		p.CompressionCandidates = append(p.CompressionCandidates, compressionHandler(name))
+	}
+	p.Adaptive = ADAPTIVE
+	p.SolidBlockSize = SOLID
+	p.DictionarySize = TRAIN_DICT
+	p.Workers = WORKERS
+
+	if PASSPHRASE != "" {
+		p.Encryption = &jpkg_impl.PassphraseEncryptionHandler{Passphrase: []byte(PASSPHRASE)}
+		p.EncryptIndex = HIDE_INDEX
+	}
 
 	fs.WalkDir(
 		os.DirFS(DIRECTORY),
@@ -85,6 +120,32 @@ func pack() {
 	if err := p.Encode(); err != nil {
 		panic(fmt.Errorf("error encoding package: %w", err))
 	}
+
+	stats := p.Stats()
+	fmt.Printf("Packed %v bytes into %v bytes, saving %.1f%%\n", stats.UncompressedSize, stats.StoredSize, stats.Savings()*100)
+}
+
+func compressionHandler(name string) jpkg_impl.CompressionHandler {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "none":
+		return &jpkg_impl.NullCompressionHandler{}
+	case "lzw":
+		return &jpkg_impl.LZWCompressionHandler{}
+	case "deflate":
+		return &jpkg_impl.DeflateCompressionHandler{Level: LEVEL}
+	case "zlib":
+		return &jpkg_impl.ZlibCompressionHandler{Level: LEVEL}
+	case "gzip":
+		return &jpkg_impl.GzipCompressionHandler{Level: LEVEL}
+	case "zstd":
+		return &jpkg_impl.ZstdCompressionHandler{Level: LEVEL}
+	case "lz4":
+		return &jpkg_impl.LZ4CompressionHandler{Level: LEVEL}
+	case "xz":
+		return &jpkg_impl.XZCompressionHandler{Preset: LEVEL, DictionarySize: DICTIONARY}
+	}
+
+	panic(fmt.Errorf("unknown compression: %v", name))
 }
 
 func unpack() {
@@ -94,7 +155,7 @@ func unpack() {
 	}
 	defer f.Close()
 
-	pkg, err := jpkg.ReadJPkg(f, nil)
+	pkg, err := jpkg.ReadJPkg(f, []byte(PASSPHRASE))
 	if err != nil {
 		panic(fmt.Errorf("error reading jpkg: %w", err))
 	}
@@ -142,7 +203,7 @@ func query() {
 	}
 	defer f.Close()
 
-	pkg, err := jpkg.ReadJPkg(f, nil)
+	pkg, err := jpkg.ReadJPkg(f, []byte(PASSPHRASE))
 	if err != nil {
 		panic(fmt.Errorf("error reading jpkg: %w", err))
 	}
@@ -1,14 +1,34 @@
 package jpkg_bin
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"reflect"
+	"unicode/utf8"
+)
+
+var (
+	ErrLimitExceeded = errors.New("limit exceeded")
+	// a length prefix larger than what's left of the input
+	ErrTruncated = errors.New("length is larger than the remaining data")
 )
 
 func BinaryRead[T any](r io.Reader) (*T, error) {
+	return BinaryReadLimited[T](r, 0)
+}
+
+// BinaryRead, failing with ErrLimitExceeded on strings longer than maxStringLength characters.
+// 0 for no limit
+func BinaryReadLimited[T any](r io.Reader, maxStringLength uint64) (*T, error) {
+	return BinaryReadEncoded[T](r, maxStringLength, STRING_UTF8)
+}
+
+// BinaryReadLimited, with strings stored in the given encoding
+func BinaryReadEncoded[T any](r io.Reader, maxStringLength uint64, encoding StringEncoding) (*T, error) {
 	rt := reflect.TypeFor[T]()
 	rv := reflect.New(rt).Elem()
 
@@ -16,6 +36,18 @@ func BinaryRead[T any](r io.Reader) (*T, error) {
 		return nil, errors.New("Binary Write only works with structs")
 	}
 
+	if err := readStruct(r, rv, maxStringLength, encoding); err != nil {
+		return nil, err
+	}
+
+	a := rv.Interface().(T)
+
+	return &a, nil
+}
+
+func readStruct(r io.Reader, rv reflect.Value, maxStringLength uint64, encoding StringEncoding) error {
+	rt := rv.Type()
+
 	for fI := range rv.NumField() {
 		if !rt.Field(fI).IsExported() {
 			continue
@@ -23,8 +55,12 @@ func BinaryRead[T any](r io.Reader) (*T, error) {
 
 		field := rv.Field(fI)
 
-		if field.Kind() == reflect.Struct {
-			return nil, errors.New("struct fields are not supported")
+		if field.Kind() == reflect.Struct { // handle embeded structs
+			if err := readStruct(r, field, maxStringLength, encoding); err != nil {
+				return fmt.Errorf("error reading struct field %v: %w", fI, err)
+			}
+
+			continue
 		}
 
 		if field.Kind() == reflect.String { // write string as sized utf8
@@ -32,31 +68,124 @@ func BinaryRead[T any](r io.Reader) (*T, error) {
 			var length uint64
 
 			if err := br(r, &length); err != nil {
-				return nil, fmt.Errorf("error reading string field %v length: %w", fI, err)
+				return fmt.Errorf("error reading string field %v length: %w", fI, err)
+			}
+
+			str, err := readString(r, length, maxStringLength, encoding)
+			if err != nil {
+				return fmt.Errorf("error reading string field %v: %w", fI, err)
 			}
 
-			str := make([]rune, length)
+			field.SetString(str)
+			continue
+		}
 
-			if err := br(r, &str); err != nil {
-				return nil, fmt.Errorf("error reading string field %v: %w", fI, err)
+		if field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.Uint8 { // read bytes as sized
+
+			var length uint64
+
+			if err := br(r, &length); err != nil {
+				return fmt.Errorf("error reading bytes field %v length: %w", fI, err)
+			}
+
+			value, err := readSized(r, length)
+			if err != nil {
+				return fmt.Errorf("error reading bytes field %v: %w", fI, err)
 			}
 
-			field.SetString(string(str))
+			field.SetBytes(value)
 			continue
 		}
 
 		fv := reflect.New(field.Type()).Interface()
 
 		if err := br(r, fv); err != nil {
-			return nil, fmt.Errorf("error reading field %v: %w", fI, err)
+			return fmt.Errorf("error reading field %v: %w", fI, err)
 		}
 
 		field.Set(reflect.ValueOf(fv).Elem())
 	}
 
-	a := rv.Interface().(T)
+	return nil
+}
 
-	return &a, nil
+// UTF-8 strings can have up to utf8.UTFMax bytes per character, so they're read if they're
+// no longer than that, then their characters are counted
+func readString(r io.Reader, length, maxStringLength uint64, encoding StringEncoding) (string, error) {
+	if encoding != STRING_RUNES {
+		if maxStringLength != 0 && length/utf8.UTFMax > maxStringLength {
+			return "", fmt.Errorf("%w: string is %v bytes long", ErrLimitExceeded, length)
+		}
+
+		encoded, err := readSized(r, length)
+		if err != nil {
+			return "", err
+		}
+
+		if characters := uint64(utf8.RuneCount(encoded)); maxStringLength != 0 && characters > maxStringLength {
+			return "", fmt.Errorf("%w: string is %v characters long", ErrLimitExceeded, characters)
+		}
+
+		return string(encoded), nil
+	}
+
+	if maxStringLength != 0 && length > maxStringLength {
+		return "", fmt.Errorf("%w: string is %v characters long", ErrLimitExceeded, length)
+	}
+
+	if length > math.MaxUint64/4 {
+		return "", fmt.Errorf("%w: string is %v characters long", ErrTruncated, length)
+	}
+
+	encoded, err := readSized(r, length*4)
+	if err != nil {
+		return "", err
+	}
+
+	str := make([]rune, length)
+
+	if err := br(bytes.NewReader(encoded), &str); err != nil {
+		return "", err
+	}
+
+	return string(str), nil
+}
+
+// reads length bytes, failing early if r is known to have fewer left. otherwise the
+// buffer only grows as data arrives, so a corrupt length can't allocate more than r holds
+func readSized(r io.Reader, length uint64) ([]byte, error) {
+	if left, known := remaining(r); (known && length > left) || length > math.MaxInt64 {
+		return nil, fmt.Errorf("%w: %v bytes", ErrTruncated, length)
+	}
+
+	b := bytes.Buffer{}
+	if _, err := io.CopyN(&b, r, int64(length)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
+
+// how many bytes are left in r, if it can tell
+func remaining(r io.Reader) (uint64, bool) {
+	switch r := r.(type) {
+	case interface{ Len() int }:
+		return uint64(r.Len()), true
+	case interface {
+		io.Seeker
+		Size() int64
+	}:
+		position, err := r.Seek(0, io.SeekCurrent)
+		if err != nil || position > r.Size() {
+			return 0, false
+		}
+		return uint64(r.Size() - position), true
+	}
+
+	return 0, false
 }
 
 func br(r io.Reader, val any) error {
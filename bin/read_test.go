@@ -2,6 +2,9 @@ package jpkg_bin
 
 import (
 	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
 	"testing"
 )
 
@@ -41,3 +44,140 @@ type test struct {
 	Value   uint64
 	Boolean bool
 }
+
+func TestReadBinaryNested(t *testing.T) {
+
+	b := bytes.Buffer{}
+
+	if err := BinaryWrite(&b, testEmbedded{test{"ABC", 7, false}, 42}); err != nil {
+		t.Logf("error binary writing: %v", err.Error())
+		t.FailNow()
+	}
+
+	v, err := BinaryRead[testEmbedded](&b)
+	if err != nil {
+		t.Logf("error binary reading: %v", err.Error())
+		t.FailNow()
+	}
+
+	if v.Inner.Name != "ABC" || v.Inner.Value != 7 || v.Inner.Boolean {
+		t.Logf("embedded struct incorrectly serialized: %+v", v.Inner)
+		t.FailNow()
+	}
+
+	if v.Offset != 42 {
+		t.Logf("offset incorrectly serialized: %v", v.Offset)
+		t.FailNow()
+	}
+}
+
+type testEmbedded struct {
+	Inner  test
+	Offset uint64
+}
+
+func TestReadBinaryBytes(t *testing.T) {
+
+	b := bytes.Buffer{}
+
+	if err := BinaryWrite(&b, testBytes{[]byte{0xDE, 0xAD, 0xBE, 0xEF}, nil}); err != nil {
+		t.Logf("error binary writing: %v", err.Error())
+		t.FailNow()
+	}
+
+	v, err := BinaryRead[testBytes](&b)
+	if err != nil {
+		t.Logf("error binary reading: %v", err.Error())
+		t.FailNow()
+	}
+
+	if !bytes.Equal(v.Data, []byte{0xDE, 0xAD, 0xBE, 0xEF}) {
+		t.Logf("bytes incorrectly serialized: %v", v.Data)
+		t.FailNow()
+	}
+
+	if len(v.Empty) != 0 {
+		t.Logf("empty bytes incorrectly serialized: %v", v.Empty)
+		t.FailNow()
+	}
+}
+
+type testBytes struct {
+	Data  []byte
+	Empty []byte
+}
+
+func TestReadBinaryLimits(t *testing.T) {
+
+	b := bytes.Buffer{}
+
+	if err := BinaryWrite(&b, test{"XYZ", 128, true}); err != nil {
+		t.Logf("error binary writing: %v", err.Error())
+		t.FailNow()
+	}
+
+	if _, err := BinaryReadLimited[test](bytes.NewReader(b.Bytes()), 2); !errors.Is(err, ErrLimitExceeded) {
+		t.Logf("string over the limit was read: %v", err)
+		t.FailNow()
+	}
+
+	corrupt := bytes.Clone(b.Bytes())
+	binary.BigEndian.PutUint64(corrupt, 1<<60)
+
+	if _, err := BinaryRead[test](bytes.NewReader(corrupt)); !errors.Is(err, ErrTruncated) {
+		t.Logf("string longer than the data was read: %v", err)
+		t.FailNow()
+	}
+
+	// without knowing the size of the input, it's only read as far as it goes
+	if _, err := BinaryRead[testBytes](io.MultiReader(bytes.NewReader(corrupt))); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Logf("bytes longer than the data were read: %v", err)
+		t.FailNow()
+	}
+}
+
+func TestReadBinaryEncodings(t *testing.T) {
+
+	for _, encoding := range []StringEncoding{STRING_UTF8, STRING_RUNES} {
+		b := bytes.Buffer{}
+
+		if err := BinaryWriteEncoded(&b, test{"XYZ", 128, true}, encoding); err != nil {
+			t.Logf("error binary writing: %v", err.Error())
+			t.FailNow()
+		}
+
+		expected := 8 + 3 + 8 + 1
+		if encoding == STRING_RUNES {
+			expected = 8 + 3*4 + 8 + 1
+		}
+
+		if b.Len() != expected {
+			t.Logf("encoding %v wrote %v bytes", encoding, b.Len())
+			t.FailNow()
+		}
+
+		v, err := BinaryReadEncoded[test](&b, 0, encoding)
+		if err != nil {
+			t.Logf("error binary reading: %v", err.Error())
+			t.FailNow()
+		}
+
+		if v.Name != "XYZ" {
+			t.Logf("name incorrectly serialized: %v", v.Name)
+			t.FailNow()
+		}
+	}
+
+	b := bytes.Buffer{}
+	BinaryWrite(&b, test{"日本語", 0, false})
+
+	if _, err := BinaryReadLimited[test](bytes.NewReader(b.Bytes()), 3); err != nil {
+		t.Logf("utf8 string within the character limit wasn't read: %v", err)
+		t.FailNow()
+	}
+
+	if _, err := BinaryReadLimited[test](bytes.NewReader(b.Bytes()), 2); !errors.Is(err, ErrLimitExceeded) {
+		t.Logf("utf8 string over the character limit was read: %v", err)
+		t.FailNow()
+	}
+}
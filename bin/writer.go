@@ -8,7 +8,22 @@ import (
 	"reflect"
 )
 
+// how strings are stored, as a uint64 length followed by the string
+type StringEncoding uint8
+
+const (
+	// the length in bytes, then the UTF-8 bytes
+	STRING_UTF8 StringEncoding = iota
+	// the length, then a big endian int32 per rune. the length written is the UTF-8 length,
+	// so only ASCII strings can be read back
+	STRING_RUNES
+)
+
 func BinaryWrite(w io.Writer, data any) error {
+	return BinaryWriteEncoded(w, data, STRING_UTF8)
+}
+
+func BinaryWriteEncoded(w io.Writer, data any, encoding StringEncoding) error {
 
 	rv := reflect.ValueOf(data)
 
@@ -27,14 +42,14 @@ func BinaryWrite(w io.Writer, data any) error {
 		value := field.Interface()
 
 		if field.Kind() == reflect.Struct { // handle embeded structs
-			if err := BinaryWrite(w, value); err != nil {
+			if err := BinaryWriteEncoded(w, value, encoding); err != nil {
 				return fmt.Errorf("error writing struct field %v: %w", fI, err)
 			}
 
 			continue
 		}
 
-		if field.Kind() == reflect.String { // write string as sized utf8
+		if field.Kind() == reflect.String { // write string as sized utf8, or as runes
 
 			value := value.(string)
 
@@ -42,13 +57,34 @@ func BinaryWrite(w io.Writer, data any) error {
 				return fmt.Errorf("error writing string field %v length: %w", fI, err)
 			}
 
-			if err := bw(w, []rune(value)); err != nil {
+			var err error
+			if encoding == STRING_RUNES {
+				err = bw(w, []rune(value))
+			} else {
+				_, err = io.WriteString(w, value)
+			}
+			if err != nil {
 				return fmt.Errorf("error writing string field %v: %w", fI, err)
 			}
 
 			continue
 		}
 
+		if field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.Uint8 { // write bytes as sized
+
+			value := value.([]byte)
+
+			if err := bw(w, uint64(len(value))); err != nil {
+				return fmt.Errorf("error writing bytes field %v length: %w", fI, err)
+			}
+
+			if _, err := w.Write(value); err != nil {
+				return fmt.Errorf("error writing bytes field %v: %w", fI, err)
+			}
+
+			continue
+		}
+
 		if err := bw(w, value); err != nil {
 			return fmt.Errorf("error writing field %v: %w", fI, err)
 		}
@@ -0,0 +1,183 @@
+package jpkg
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+
+	jpkg_bin "github.com/j4d3blooded/JPkg/bin"
+)
+
+// hash of the header and manifest, part of every chunk's associated data so they're
+// authenticated along with the file data
+func packageContext(header JPkgHeader, manifest JPkgManifest) ([]byte, error) {
+	context := sha256.New()
+
+	if err := jpkg_bin.BinaryWrite(context, header); err != nil {
+		return nil, fmt.Errorf("error hashing header: %w", err)
+	}
+
+	if err := jpkg_bin.BinaryWriteEncoded(context, manifest, header.strings()); err != nil {
+		return nil, fmt.Errorf("error hashing manifest: %w", err)
+	}
+
+	return context.Sum(nil), nil
+}
+
+// the encrypted index holds the manifest, so it's bound to the header alone
+func indexAssociatedData(header JPkgHeader) ([]byte, error) {
+	b := bytes.Buffer{}
+	b.WriteString("jpkg index")
+	if err := jpkg_bin.BinaryWrite(&b, header); err != nil {
+		return nil, fmt.Errorf("error writing header: %w", err)
+	}
+	return b.Bytes(), nil
+}
+
+type jpkgRecordIdentity struct {
+	Context    []byte
+	Index      uint64
+	UUID       UUID
+	Path       string
+	Identifier string
+	Metadata   string
+}
+
+// binds a record's chunks to the package and to the record's position and identity, so
+// a chunk moved to another record, or another position in the same record, won't decrypt
+func recordAssociatedData(identity jpkgRecordIdentity, encoding jpkg_bin.StringEncoding) ([]byte, error) {
+	b := bytes.Buffer{}
+	if err := jpkg_bin.BinaryWriteEncoded(&b, identity, encoding); err != nil {
+		return nil, fmt.Errorf("error writing record identity: %w", err)
+	}
+	return b.Bytes(), nil
+}
+
+// the last chunk is marked, so a record with its trailing chunks dropped won't decrypt
+func chunkAssociatedData(recordAssociatedData []byte, idx int, last bool) []byte {
+	associatedData := binary.BigEndian.AppendUint64(bytes.Clone(recordAssociatedData), uint64(idx))
+	if last {
+		return append(associatedData, 1)
+	}
+	return append(associatedData, 0)
+}
+
+type jpkgChunkTable struct {
+	chunkSize uint64
+	// offsets of every chunk relative to the record data, plus the end of the last chunk
+	offsets []uint64
+}
+
+// the chunks size bytes are split into
+func chunksOf(size, chunkSize uint64) uint64 {
+	count := size / chunkSize
+	if size%chunkSize != 0 {
+		count++
+	}
+	return count
+}
+
+func (c *jpkgChunkTable) count() int {
+	return len(c.offsets) - 1
+}
+
+func (j *JPkg) readChunkTable(data *io.SectionReader, info jpkgFileOpenerInfo) (jpkgChunkTable, error) {
+	if !j.chunkedRecords { // older packages store each file as a single chunk
+		return jpkgChunkTable{
+			chunkSize: max(info.uncompressedSize, 1),
+			offsets:   []uint64{0, info.compressedSize},
+		}, nil
+	}
+
+	if info.compressedSize < 16 {
+		return jpkgChunkTable{}, newCorrupt(info.offset, info.recordIndex(), "record is too small to have a chunk table")
+	}
+
+	var chunkSize, chunkCount uint64
+	tail := io.NewSectionReader(data, int64(info.compressedSize)-16, 16)
+	if err := binary.Read(tail, binary.BigEndian, &chunkSize); err != nil {
+		return jpkgChunkTable{}, fmt.Errorf("error reading chunk size: %w", asCorrupt(info.offset, info.recordIndex(), err))
+	}
+	if err := binary.Read(tail, binary.BigEndian, &chunkCount); err != nil {
+		return jpkgChunkTable{}, fmt.Errorf("error reading chunk count: %w", asCorrupt(info.offset, info.recordIndex(), err))
+	}
+
+	// a chunk is never larger than the record, so offsets within it always fit an int64
+	if chunkSize == 0 || chunkSize > max(info.uncompressedSize, 1) || chunkSize > math.MaxInt64 {
+		return jpkgChunkTable{}, newCorrupt(info.offset, info.recordIndex(), "chunk size %v does not match uncompressed size", chunkSize)
+	}
+
+	// empty records are a single empty chunk, so there's always a last chunk
+	if chunkCount != max(chunksOf(info.uncompressedSize, chunkSize), 1) {
+		return jpkgChunkTable{}, newCorrupt(info.offset, info.recordIndex(), "chunk table does not match uncompressed size")
+	}
+
+	if chunkCount > (info.compressedSize-16)/8 {
+		return jpkgChunkTable{}, newCorrupt(info.offset, info.recordIndex(), "chunk table is larger than the record")
+	}
+
+	tableSize := chunkCount*8 + 16
+
+	table := io.NewSectionReader(data, int64(info.compressedSize-tableSize), int64(chunkCount*8))
+
+	sizes := make([]uint64, chunkCount)
+	if err := binary.Read(table, binary.BigEndian, sizes); err != nil {
+		return jpkgChunkTable{}, fmt.Errorf("error reading chunk table: %w", asCorrupt(info.offset, info.recordIndex(), err))
+	}
+
+	offsets := make([]uint64, chunkCount+1)
+	for i, size := range sizes {
+		offsets[i+1] = offsets[i] + size
+	}
+
+	if offsets[chunkCount] != info.compressedSize-tableSize {
+		return jpkgChunkTable{}, newCorrupt(info.offset, info.recordIndex(), "chunk sizes do not match record size")
+	}
+
+	return jpkgChunkTable{chunkSize, offsets}, nil
+}
+
+// reads, decrypts and decompresses a single chunk of a record
+func (j *JPkgFile) readChunk(idx int, expectedSize uint64) ([]byte, error) {
+	start, end := j.chunks.offsets[idx], j.chunks.offsets[idx+1]
+
+	encrypted := io.NewSectionReader(j.data, int64(start), int64(end-start))
+
+	decrypted, decryptedSize, err := j.eHandler.DecryptAt(encrypted, encrypted.Size(), chunkAssociatedData(j.associatedData, idx, idx == j.chunks.count()-1))
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting chunk data: %w", err)
+	}
+
+	decompressor, err := j.cHandler.DecompressReader(io.NewSectionReader(decrypted, 0, decryptedSize))
+	if err != nil {
+		return nil, fmt.Errorf("error creating decompressor: %w", j.corrupt(start, err))
+	}
+	defer decompressor.Close()
+
+	// the buffer grows as data decompresses, so a chunk claiming to be huge only
+	// allocates what it really decompresses to
+	chunk := bytes.Buffer{}
+	n, err := io.CopyN(&chunk, decompressor, int64(expectedSize)+1)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("error decompressing chunk data: %w", j.corrupt(start, err))
+	}
+
+	if uint64(n) < expectedSize {
+		return nil, fmt.Errorf("error decompressing chunk data: %w", j.corrupt(start, io.ErrUnexpectedEOF))
+	}
+
+	if uint64(n) > expectedSize {
+		return nil, j.corrupt(start, fmt.Errorf("chunk decompressed to more than %v bytes", expectedSize))
+	}
+
+	return chunk.Bytes(), nil
+}
+
+// chunks that decrypted but don't decompress are corrupt, at their offset in the package
+func (j *JPkgFile) corrupt(chunkStart uint64, err error) error {
+	_, base, _ := j.data.Outer()
+	return &ErrCorrupt{base + int64(chunkStart), j.recordIndex, err}
+}
@@ -0,0 +1,72 @@
+package jpkg
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	jpkg_impl "github.com/j4d3blooded/JPkg/impl"
+)
+
+// trains a dictionary from the start of every file, unless one was given. files keep
+// the data that was sampled from them
+func (j *JPkgEncoder) prepareDictionary() error {
+	if j.Dictionary != nil || j.DictionarySize == 0 {
+		_, err := j.withDictionary(j.Compression)
+		return err
+	}
+
+	handler, isDictionaryHandler := j.Compression.(jpkg_impl.DictionaryCompressionHandler)
+	if !isDictionaryHandler {
+		return fmt.Errorf("compression %v doesn't support dictionaries", j.Compression.Flag())
+	}
+
+	budget := j.DictionarySize * DICTIONARY_SAMPLE_RATIO
+	samples := [][]byte{}
+
+	for i, file := range j.files {
+		if budget == 0 {
+			break
+		}
+
+		if file.compression != nil && file.compression.Flag() != j.Compression.Flag() {
+			continue
+		}
+
+		sample := bytes.Buffer{}
+		if _, err := io.CopyN(&sample, file.source, int64(min(DICTIONARY_SAMPLE_SIZE, budget))); err != nil && err != io.EOF {
+			return fmt.Errorf("error sampling file (%v/%v/%v): %w", file.path, file.identifier, file.uuid, err)
+		}
+
+		j.files[i].source = io.MultiReader(bytes.NewReader(sample.Bytes()), file.source)
+		samples = append(samples, sample.Bytes())
+		budget -= uint64(sample.Len())
+	}
+
+	dictionary, err := handler.TrainDictionary(samples, int(j.DictionarySize))
+	if err != nil {
+		return fmt.Errorf("error training dictionary: %w", err)
+	}
+
+	j.Dictionary = dictionary
+	return nil
+}
+
+// the dictionary is only used by the package compression, files compressed with anything
+// else don't use it
+func (j *JPkgEncoder) withDictionary(compression jpkg_impl.CompressionHandler) (jpkg_impl.CompressionHandler, error) {
+	if j.Dictionary == nil || compression.Flag() != j.Compression.Flag() {
+		return compression, nil
+	}
+
+	return loadDictionary(compression, j.Dictionary)
+}
+
+func loadDictionary(compression jpkg_impl.CompressionHandler, dictionary []byte) (jpkg_impl.CompressionHandler, error) {
+	handler, isDictionaryHandler := compression.(jpkg_impl.DictionaryCompressionHandler)
+	if !isDictionaryHandler {
+		return nil, fmt.Errorf("compression %v doesn't support dictionaries", compression.Flag())
+	}
+
+	return handler.WithDictionary(dictionary)
+}
@@ -6,11 +6,12 @@ import (
 )
 
 type JPkgDirInfo struct {
-	pkg   *JPkg
-	path  string
-	name  string
-	size  int64
-	isDir bool
+	pkg    *JPkg
+	path   string
+	name   string
+	size   int64
+	isDir  bool
+	digest []byte
 }
 
 // Info implements fs.DirEntry.
@@ -56,3 +57,9 @@ func (j *JPkgDirInfo) Size() int64 {
 func (j *JPkgDirInfo) Sys() any {
 	return nil
 }
+
+// Digest returns the hash of the uncompressed file data, nil for directories
+// and files in packages that aren't hashed.
+func (j *JPkgDirInfo) Digest() []byte {
+	return j.digest
+}
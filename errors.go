@@ -0,0 +1,76 @@
+package jpkg
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+
+	jpkg_bin "github.com/j4d3blooded/JPkg/bin"
+	jpkg_impl "github.com/j4d3blooded/JPkg/impl"
+)
+
+var (
+	ErrSignatureInvalid  = errors.New("package signature is invalid")
+	ErrNotHashed         = errors.New("package has no hash")
+	ErrIntegrityMismatch = errors.New("package hash does not match its contents")
+	ErrChecksumMismatch  = errors.New("file digest does not match its contents")
+	ErrWrongKey          = jpkg_impl.ErrWrongKey
+	// tampered or transplanted encrypted data
+	ErrAuthenticationFailed = jpkg_impl.ErrAuthenticationFailed
+	// a compression, encryption, hash or signature flag without a registered handler
+	ErrUnsupportedFeature = jpkg_impl.ErrUnsupportedFeature
+	// the package is larger than a ReaderOptions limit allows
+	ErrLimitExceeded = jpkg_bin.ErrLimitExceeded
+	// the data doesn't start with the jpkg magic number
+	ErrBadMagic           = errors.New("not a jpkg package")
+	ErrUnsupportedVersion = errors.New("unsupported package version")
+	// two files with the same path, when adding files or in a package being read
+	ErrDuplicatePath = errors.New("path is already in use")
+	// the same as fs.ErrNotExist, so either can be checked for
+	ErrNotFound = fs.ErrNotExist
+)
+
+// ErrCorrupt is returned for packages whose structure is invalid, found either when
+// reading the package or when opening a file in it
+type ErrCorrupt struct {
+	// where in the package the problem was found, -1 if unknown
+	Offset int64
+	// the index of the record the problem is in, -1 if it's not in a record
+	RecordIndex int64
+	Err         error
+}
+
+func (e *ErrCorrupt) Error() string {
+	message := "corrupt package"
+	if e.Offset >= 0 {
+		message += fmt.Sprintf(" at offset %v", e.Offset)
+	}
+	if e.RecordIndex >= 0 {
+		message += fmt.Sprintf(" in record %v", e.RecordIndex)
+	}
+	return fmt.Sprintf("%v: %v", message, e.Err)
+}
+
+func (e *ErrCorrupt) Unwrap() error {
+	return e.Err
+}
+
+func newCorrupt(offset, recordIndex int64, format string, a ...any) error {
+	return &ErrCorrupt{offset, recordIndex, fmt.Errorf(format, a...)}
+}
+
+// errors from reading the package that mean it's cut short or malformed are wrapped in
+// ErrCorrupt, anything else, such as an exceeded limit, is returned as it is
+func asCorrupt(offset, recordIndex int64, err error) error {
+	var corrupt *ErrCorrupt
+	if errors.As(err, &corrupt) {
+		return err
+	}
+
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, jpkg_bin.ErrTruncated) {
+		return &ErrCorrupt{offset, recordIndex, err}
+	}
+
+	return err
+}
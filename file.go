@@ -4,8 +4,14 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"hash"
+	"io"
 	"io/fs"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	jpkg_impl "github.com/j4d3blooded/JPkg/impl"
 )
 
 type jpkgFileOpenerInfo struct {
@@ -17,6 +23,20 @@ type jpkgFileOpenerInfo struct {
 	uncompressedSize uint64
 	offset           int64
 	metadata         []byte
+	digest           []byte
+	index            uint64
+	compressionFlag  jpkg_impl.CompressionFlag
+	encryptionFlag   jpkg_impl.EncryptionFlag
+	solid            bool
+	blockOffset      uint64
+}
+
+// the index reported in ErrCorrupt, -1 for solid blocks which hold many records
+func (i jpkgFileOpenerInfo) recordIndex() int64 {
+	if i.solid {
+		return -1
+	}
+	return int64(i.index)
 }
 
 type JPkgFile struct {
@@ -27,8 +47,22 @@ type JPkgFile struct {
 	uuid       UUID
 	size       int64
 	metadata   []byte
-	buffer     bytes.Reader
-	closed     bool
+	data       *io.SectionReader
+	chunks     jpkgChunkTable
+	position   int64
+	closed     atomic.Bool
+	lock       sync.Mutex
+	chunkIdx   int
+	chunk      []byte
+	digest     []byte
+	verifier   hash.Hash
+	verified   int64
+	cHandler   jpkg_impl.CompressionHandler
+	eHandler   jpkg_impl.EncryptionHandler
+	// the index of the record the data is from, -1 for solid blocks
+	recordIndex int64
+	// prefix of the associated data of every chunk
+	associatedData []byte
 }
 
 func (j *JPkgFile) IsDir() bool {
@@ -52,11 +86,128 @@ func (j *JPkgFile) Size() int64 {
 }
 
 func (j *JPkgFile) Read(b []byte) (int, error) {
-	if j.closed {
-		return -1, fs.ErrClosed
+	if j.closed.Load() {
+		return 0, fs.ErrClosed
 	}
 
-	return j.buffer.Read(b)
+	n, err := j.ReadAt(b, j.position)
+
+	if j.verifier != nil && j.position == j.verified {
+		j.verifier.Write(b[:n])
+		j.verified += int64(n)
+
+		if j.verified == j.size && !bytes.Equal(j.verifier.Sum(nil), j.digest) {
+			j.verifier = nil
+			return n, fmt.Errorf("%w: %v", ErrChecksumMismatch, j.path)
+		}
+	}
+
+	j.position += int64(n)
+
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+
+	return n, err
+}
+
+// Digest returns the hash of the uncompressed file data, computed with the package's hasher.
+// nil if the package isn't hashed
+func (j *JPkgFile) Digest() []byte {
+	return j.digest
+}
+
+// GetFlags returns how this file is stored, which can differ from the package's flags
+func (j *JPkgFile) GetFlags() (jpkg_impl.CompressionFlag, jpkg_impl.EncryptionFlag) {
+	return j.cHandler.Flag(), j.eHandler.Flag()
+}
+
+// ReadAt implements io.ReaderAt, only the chunks overlapping b are decoded.
+func (j *JPkgFile) ReadAt(b []byte, off int64) (int, error) {
+	if j.closed.Load() {
+		return 0, fs.ErrClosed
+	}
+
+	if off < 0 {
+		return 0, fs.ErrInvalid
+	}
+
+	n := 0
+
+	for n < len(b) {
+		if off >= j.size {
+			return n, io.EOF
+		}
+
+		chunkSize := int64(j.chunks.chunkSize)
+		idx := int(off / chunkSize)
+
+		chunk, err := j.getChunk(idx)
+		if err != nil {
+			return n, fmt.Errorf("error reading chunk %v of %v: %w", idx, j.path, err)
+		}
+
+		copied := copy(b[n:], chunk[off-int64(idx)*chunkSize:])
+		n += copied
+		off += int64(copied)
+	}
+
+	return n, nil
+}
+
+func (j *JPkgFile) getChunk(idx int) ([]byte, error) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	// Close may have run since ReadAt checked
+	if j.closed.Load() {
+		return nil, fs.ErrClosed
+	}
+
+	if idx == j.chunkIdx {
+		return j.chunk, nil
+	}
+
+	chunkStart := uint64(idx) * j.chunks.chunkSize
+	expectedSize := min(j.chunks.chunkSize, uint64(j.size)-chunkStart)
+
+	chunk, err := j.readChunk(idx, expectedSize)
+	if err != nil {
+		return nil, err
+	}
+
+	j.chunkIdx = idx
+	j.chunk = chunk
+	return chunk, nil
+}
+
+// Seek implements io.Seeker.
+func (j *JPkgFile) Seek(offset int64, whence int) (int64, error) {
+	if j.closed.Load() {
+		return 0, fs.ErrClosed
+	}
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += j.position
+	case io.SeekEnd:
+		offset += j.size
+	default:
+		return 0, fs.ErrInvalid
+	}
+
+	if offset < 0 {
+		return 0, fs.ErrInvalid
+	}
+
+	if offset == 0 && j.verifier != nil { // reading from the start again can verify again
+		j.verifier.Reset()
+		j.verified = 0
+	}
+
+	j.position = offset
+	return offset, nil
 }
 
 func (j *JPkgFile) Stat() (fs.FileInfo, error) {
@@ -64,7 +215,11 @@ func (j *JPkgFile) Stat() (fs.FileInfo, error) {
 }
 
 func (j *JPkgFile) Close() error {
-	j.closed = true
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	j.closed.Store(true)
+	j.chunk = nil
 	return nil
 }
 
@@ -0,0 +1,128 @@
+package jpkg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/fs"
+	"math"
+	"testing"
+
+	jpkg_bin "github.com/j4d3blooded/JPkg/bin"
+	jpkg_impl "github.com/j4d3blooded/JPkg/impl"
+)
+
+// a package with a single record of data stored as is, claiming to decompress to
+// uncompressedSize bytes, so the chunk table at the end of data can be forged
+func forgedTestPackage(data []byte, uncompressedSize uint64) []byte {
+	b := bytes.Buffer{}
+
+	jpkg_bin.BinaryWrite(&b, JPkgHeader{MagicNumber: MAGIC_NUMBER, Version: FORMAT_VERSION})
+	b.Write(padding(uint64(b.Len())))
+	jpkg_bin.BinaryWrite(&b, JPkgManifest{FileCount: 1, PackageMetadataJSON: "{}"})
+
+	record := JPkgFileRecordWithoutData{
+		FilePath:             "\\forged.bin",
+		FileMetadataJSON:     "{}",
+		CompressedDataSize:   uint64(len(data)),
+		UncompressedDataSize: uncompressedSize,
+	}
+	jpkg_bin.BinaryWrite(&b, record)
+	b.Write(padding(uint64(b.Len())))
+
+	offset := uint64(b.Len())
+	b.Write(data)
+
+	directoryOffset := uint64(b.Len())
+	jpkg_bin.BinaryWrite(&b, JPkgFileRecordWithOffset{JPkgFileRecordWithoutData: record, Offset: offset})
+	jpkg_bin.BinaryWrite(&b, JPkgTrailer{
+		MagicNumber:     TRAILER_MAGIC_NUMBER,
+		DirectoryOffset: directoryOffset,
+		DirectorySize:   uint64(b.Len()) - directoryOffset,
+		Flags:           TRAILER_FLAG_CHUNKED_RECORDS | TRAILER_FLAG_RECORD_FLAGS,
+	})
+
+	return b.Bytes()
+}
+
+// a chunk table with no chunks, whose chunk size wraps the chunk count around to 0
+func forgedChunkTable() []byte {
+	return binary.BigEndian.AppendUint64(binary.BigEndian.AppendUint64(nil, math.MaxUint64-5), 0)
+}
+
+// a single stored byte, with a chunk table claiming it's a chunk of size bytes
+func forgedBomb(size uint64) []byte {
+	return binary.BigEndian.AppendUint64(binary.BigEndian.AppendUint64(binary.BigEndian.AppendUint64([]byte{0x42}, 1), size), 1)
+}
+
+// rewrites the trailer of an unhashed and unsigned package so that its directory offset
+// plus size wraps around to the trailer's own offset
+func forgedTrailer(data []byte) []byte {
+	forged := bytes.Clone(data)
+	n := uint64(len(forged))
+	trailer := forged[n-TRAILER_SIZE:]
+	binary.BigEndian.PutUint64(trailer[4:], n-10)
+	binary.BigEndian.PutUint64(trailer[12:], (n-TRAILER_SIZE)-(n-10))
+	return forged
+}
+
+// packages from untrusted sources must fail to read with an error, never panic or allocate
+// more than the limits allow
+func FuzzReadJPkg(f *testing.F) {
+	key := bytes.Repeat([]byte{0x42}, 32)
+
+	seeds := []func(e *JPkgEncoder){
+		nil,
+		func(e *JPkgEncoder) {
+			e.Compression = &jpkg_impl.ZstdCompressionHandler{}
+			e.Hasher = &jpkg_impl.SHA256HasherHandler{}
+		},
+		func(e *JPkgEncoder) {
+			e.Compression = &jpkg_impl.DeflateCompressionHandler{}
+			e.SolidBlockSize = 1024
+		},
+		func(e *JPkgEncoder) {
+			e.Encryption = &jpkg_impl.AESEncryptionHandler{Key: key}
+			e.EncryptIndex = true
+		},
+	}
+
+	for _, seed := range seeds {
+		b := bytes.Buffer{}
+		encodeTestPackageTo(f, &b, seed)
+		f.Add(b.Bytes())
+	}
+
+	b := bytes.Buffer{}
+	encodeTestPackageTo(f, &b, nil)
+	f.Add(forgedTrailer(b.Bytes()))
+
+	f.Add(forgedTestPackage(forgedChunkTable(), 15))
+	f.Add(forgedTestPackage(forgedBomb(1<<50), 1<<50))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		pkg, err := ReadJPkgAt(bytes.NewReader(data), int64(len(data)), ReaderOptions{
+			EncryptionKey:       key,
+			MaxStringLength:     1024,
+			MaxMetadataSize:     4096,
+			MaxFileCount:        1024,
+			MaxUncompressedSize: 1 << 24,
+			MaxCompressionRatio: 1024,
+		})
+		if err != nil {
+			return
+		}
+
+		fs.WalkDir(pkg, ".", func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return nil
+			}
+
+			if f, err := pkg.Open(path); err == nil {
+				io.Copy(io.Discard, f)
+				f.Close()
+			}
+			return nil
+		})
+	})
+}
@@ -1,3 +1,9 @@
 module github.com/j4d3blooded/JPkg
 
 go 1.24.5
+
+require (
+	github.com/klauspost/compress v1.18.0
+	github.com/pierrec/lz4/v4 v4.1.31
+	github.com/ulikunitz/xz v0.5.17
+)
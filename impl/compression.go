@@ -12,12 +12,24 @@ type CompressionFlag uint8
 const (
 	COMPRESSION_NONE CompressionFlag = iota
 	COMPRESSION_LZW
+	COMPRESSION_DEFLATE
+	COMPRESSION_ZLIB
+	COMPRESSION_GZIP
+	COMPRESSION_ZSTD
+	COMPRESSION_LZ4
+	COMPRESSION_XZ
 )
 
+// the largest window or dictionary a compressed stream may ask for. decoders allocate
+// the whole window up front, so streams asking for more are rejected before decoding
+const MAX_WINDOW_SIZE = 64 << 20
+
 type CompressionHandler interface {
 	Flag() CompressionFlag
 	Decompress(compressed []byte) ([]byte, error)
 	Compress(uncompressed []byte) ([]byte, error)
+	DecompressReader(compressed io.Reader) (io.ReadCloser, error)
+	CompressWriter(output io.Writer) (io.WriteCloser, error)
 }
 
 type NullCompressionHandler struct {
@@ -36,6 +48,14 @@ func (n *NullCompressionHandler) Compress(uncompressed []byte) ([]byte, error) {
 	return uncompressed, nil
 }
 
+func (n *NullCompressionHandler) DecompressReader(compressed io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(compressed), nil
+}
+
+func (n *NullCompressionHandler) CompressWriter(output io.Writer) (io.WriteCloser, error) {
+	return newNopWriterCloser(output), nil
+}
+
 type LZWCompressionHandler struct {
 }
 
@@ -69,3 +89,43 @@ func (n *LZWCompressionHandler) Compress(uncompressed []byte) ([]byte, error) {
 	}
 	return output.Bytes(), nil
 }
+
+func (n *LZWCompressionHandler) DecompressReader(compressed io.Reader) (io.ReadCloser, error) {
+	return lzw.NewReader(compressed, lzw.LSB, 8), nil
+}
+
+func (n *LZWCompressionHandler) CompressWriter(output io.Writer) (io.WriteCloser, error) {
+	return lzw.NewWriter(output, lzw.LSB, 8), nil
+}
+
+// implements Compress for handlers that only differ in their streaming writer
+func compressBytes(handler CompressionHandler, uncompressed []byte) ([]byte, error) {
+	output := &bytes.Buffer{}
+	writer, err := handler.CompressWriter(output)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := writer.Write(uncompressed); err != nil {
+		return nil, fmt.Errorf("error during compression: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("error closing compression: %w", err)
+	}
+	return output.Bytes(), nil
+}
+
+// implements Decompress for handlers that only differ in their streaming reader
+func decompressBytes(handler CompressionHandler, compressed []byte) ([]byte, error) {
+	reader, err := handler.DecompressReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, err
+	}
+	b, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("error during decompression: %w", err)
+	}
+	if err := reader.Close(); err != nil {
+		return nil, fmt.Errorf("error closing decompression: %w", err)
+	}
+	return b, nil
+}
@@ -0,0 +1,156 @@
+package jpkg_impl
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+var testCompressionHandlers = []CompressionHandler{
+	&NullCompressionHandler{},
+	&LZWCompressionHandler{},
+	&DeflateCompressionHandler{},
+	&DeflateCompressionHandler{Level: 1},
+	&ZlibCompressionHandler{Level: 9},
+	&GzipCompressionHandler{},
+	&ZstdCompressionHandler{},
+	&ZstdCompressionHandler{Level: 19},
+	&LZ4CompressionHandler{},
+	&LZ4CompressionHandler{Level: 9},
+	&XZCompressionHandler{},
+	&XZCompressionHandler{Preset: 1, DictionarySize: 1 << 16},
+}
+
+func TestCompressionRoundTrip(t *testing.T) {
+	data := bytes.Repeat([]byte(`{"name":"asset","values":[1,2,3]}`), 2000)
+
+	for _, handler := range testCompressionHandlers {
+		compressed, err := handler.Compress(data)
+		if err != nil {
+			t.Logf("error compressing with %T: %v", handler, err.Error())
+			t.FailNow()
+		}
+
+		decompressed, err := handler.Decompress(compressed)
+		if err != nil || !bytes.Equal(decompressed, data) {
+			t.Logf("%T incorrectly round tripped: %v", handler, err)
+			t.FailNow()
+		}
+
+		registered, err := GetCompressionHandler(handler.Flag())
+		if err != nil {
+			t.Logf("no handler registered for flag %v: %v", handler.Flag(), err.Error())
+			t.FailNow()
+		}
+
+		reader, err := registered.DecompressReader(bytes.NewReader(compressed))
+		if err != nil {
+			t.Logf("error creating decompressor for flag %v: %v", handler.Flag(), err.Error())
+			t.FailNow()
+		}
+
+		streamed, err := io.ReadAll(reader)
+		if err != nil || !bytes.Equal(streamed, data) {
+			t.Logf("flag %v incorrectly streamed: %v", handler.Flag(), err)
+			t.FailNow()
+		}
+	}
+}
+
+const localizationTestFormat = `{"schema":"localization/v2","id":%v,"language":"en-GB",` +
+	`"text":"string %v","context":"menus","maxLength":%v,"reviewed":true,"tags":["ui","menu"]}`
+
+func TestDictionaryCompression(t *testing.T) {
+	samples := [][]byte{}
+	for i := range 500 {
+		samples = append(samples, fmt.Appendf(nil, localizationTestFormat, i, i*7, i%13))
+	}
+	data := fmt.Appendf(nil, localizationTestFormat, 9999, "something else", 3)
+
+	for _, handler := range []DictionaryCompressionHandler{&ZstdCompressionHandler{}, &DeflateCompressionHandler{}, &ZlibCompressionHandler{}} {
+		dictionary, err := handler.TrainDictionary(samples, 4096)
+		if err != nil {
+			t.Logf("error training dictionary for %v: %v", handler.Flag(), err.Error())
+			t.FailNow()
+		}
+
+		withDictionary, err := handler.WithDictionary(dictionary)
+		if err != nil {
+			t.Logf("error loading dictionary for %v: %v", handler.Flag(), err.Error())
+			t.FailNow()
+		}
+
+		compressed, err := withDictionary.Compress(data)
+		if err != nil {
+			t.Logf("error compressing with dictionary for %v: %v", handler.Flag(), err.Error())
+			t.FailNow()
+		}
+
+		without, _ := handler.Compress(data)
+		if len(compressed) >= len(without) {
+			t.Logf("dictionary didn't help %v: %v >= %v", handler.Flag(), len(compressed), len(without))
+			t.FailNow()
+		}
+
+		decompressed, err := withDictionary.Decompress(compressed)
+		if err != nil || !bytes.Equal(decompressed, data) {
+			t.Logf("%v incorrectly round tripped with dictionary: %v", handler.Flag(), err)
+			t.FailNow()
+		}
+	}
+}
+
+func TestXZDictionaryLimit(t *testing.T) {
+	data := bytes.Repeat([]byte("some data that compresses "), 4000)
+
+	// several blocks, so every block header is checked and skipped over
+	compressed := bytes.Buffer{}
+	writer, err := xz.WriterConfig{DictCap: 1 << 16, BlockSize: 1 << 14}.NewWriter(&compressed)
+	if err != nil {
+		t.Logf("error creating xz writer: %v", err.Error())
+		t.FailNow()
+	}
+	writer.Write(data)
+	writer.Close()
+
+	handler := &XZCompressionHandler{}
+	decompressed, err := handler.Decompress(compressed.Bytes())
+	if err != nil || !bytes.Equal(decompressed, data) {
+		t.Logf("multi block xz stream incorrectly decompressed: %v", err)
+		t.FailNow()
+	}
+
+	// the first block header starts after the 12 byte stream header, and names a
+	// dictionary of almost 4GiB
+	forged := bytes.Clone(compressed.Bytes())
+	header := forged[12 : 12+(int(forged[12])+1)*4]
+	header[4] = 40
+	binary.LittleEndian.PutUint32(header[len(header)-4:], crc32.ChecksumIEEE(header[:len(header)-4]))
+
+	if _, err := handler.Decompress(forged); !errors.Is(err, errXZMalformed) {
+		t.Logf("expected a huge xz dictionary to be rejected, got %v", err)
+		t.FailNow()
+	}
+
+	if _, err := (&XZCompressionHandler{DictionarySize: XZ_MAX_DICTIONARY_SIZE + 1}).Compress(data); err == nil {
+		t.Logf("expected a dictionary larger than XZ_MAX_DICTIONARY_SIZE to be refused")
+		t.FailNow()
+	}
+}
+
+func TestZstdWindowLimit(t *testing.T) {
+	// an empty frame with a window descriptor asking for a 512MiB window
+	frame := []byte{0x28, 0xB5, 0x2F, 0xFD, 0x00, 19 << 3, 0x01, 0x00, 0x00}
+
+	if _, err := (&ZstdCompressionHandler{}).Decompress(frame); !errors.Is(err, zstd.ErrWindowSizeExceeded) {
+		t.Logf("expected a huge zstd window to be rejected, got %v", err)
+		t.FailNow()
+	}
+}
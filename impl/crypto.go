@@ -1,13 +1,28 @@
 package jpkg_impl
 
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"hash"
+)
+
 type CryptoFlag uint8
 
 const (
 	CRYPTO_NONE CryptoFlag = iota
+	CRYPTO_ED25519
 )
 
+// signs the digest of every byte in the package before the signature
 type CryptoHandler interface {
 	Flag() CryptoFlag
+	SignatureSize() int
+	NewHash() hash.Hash
+	Sign(digest []byte) ([]byte, error)
+	Verify(digest []byte, signature []byte) bool
 }
 
 type NullCryptoHandler struct {
@@ -16,3 +31,60 @@ type NullCryptoHandler struct {
 func (n *NullCryptoHandler) Flag() CryptoFlag {
 	return CRYPTO_NONE
 }
+
+func (n *NullCryptoHandler) SignatureSize() int {
+	return 0
+}
+
+func (n *NullCryptoHandler) NewHash() hash.Hash {
+	return nil
+}
+
+func (n *NullCryptoHandler) Sign(digest []byte) ([]byte, error) {
+	return nil, nil
+}
+
+func (n *NullCryptoHandler) Verify(digest []byte, signature []byte) bool {
+	return false
+}
+
+// Ed25519ph, the package is prehashed with SHA-512 so it never needs to be held in memory.
+// PrivateKey is only needed for signing and PublicKey only for verifying
+type Ed25519CryptoHandler struct {
+	PrivateKey ed25519.PrivateKey
+	PublicKey  ed25519.PublicKey
+}
+
+var ed25519Options = &ed25519.Options{Hash: crypto.SHA512, Context: "jpkg"}
+
+func (e *Ed25519CryptoHandler) Flag() CryptoFlag {
+	return CRYPTO_ED25519
+}
+
+func (e *Ed25519CryptoHandler) SignatureSize() int {
+	return ed25519.SignatureSize
+}
+
+func (e *Ed25519CryptoHandler) NewHash() hash.Hash {
+	return sha512.New()
+}
+
+func (e *Ed25519CryptoHandler) Sign(digest []byte) ([]byte, error) {
+	if len(e.PrivateKey) != ed25519.PrivateKeySize {
+		return nil, errors.New("ed25519 private key has incorrect length")
+	}
+
+	signature, err := e.PrivateKey.Sign(nil, digest, ed25519Options)
+	if err != nil {
+		return nil, fmt.Errorf("error during ed25519 signing: %w", err)
+	}
+	return signature, nil
+}
+
+func (e *Ed25519CryptoHandler) Verify(digest []byte, signature []byte) bool {
+	if len(e.PublicKey) != ed25519.PublicKeySize {
+		return false
+	}
+
+	return ed25519.VerifyWithOptions(e.PublicKey, digest, signature, ed25519Options) == nil
+}
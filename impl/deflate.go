@@ -0,0 +1,152 @@
+package jpkg_impl
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// the DEFLATE window, dictionaries larger than this can't be referenced
+const DEFLATE_MAX_DICTIONARY_SIZE = 32 * 1024
+
+// levels are the same as compress/flate, from 1 (fastest) to 9 (best), and 0 uses the default
+func deflateLevel(level int) int {
+	if level == 0 {
+		return flate.DefaultCompression
+	}
+	return level
+}
+
+// raw DEFLATE, without any framing
+type DeflateCompressionHandler struct {
+	Level      int
+	dictionary []byte
+}
+
+// Flag implements CompressionHandler.
+func (d *DeflateCompressionHandler) Flag() CompressionFlag {
+	return COMPRESSION_DEFLATE
+}
+
+func (d *DeflateCompressionHandler) Decompress(compressed []byte) ([]byte, error) {
+	return decompressBytes(d, compressed)
+}
+
+func (d *DeflateCompressionHandler) Compress(uncompressed []byte) ([]byte, error) {
+	return compressBytes(d, uncompressed)
+}
+
+func (d *DeflateCompressionHandler) DecompressReader(compressed io.Reader) (io.ReadCloser, error) {
+	return flate.NewReaderDict(compressed, d.dictionary), nil
+}
+
+func (d *DeflateCompressionHandler) CompressWriter(output io.Writer) (io.WriteCloser, error) {
+	writer, err := flate.NewWriterDict(output, deflateLevel(d.Level), d.dictionary)
+	if err != nil {
+		return nil, fmt.Errorf("error creating deflate compressor: %w", err)
+	}
+	return writer, nil
+}
+
+// TrainDictionary implements DictionaryCompressionHandler. DEFLATE can only reference the
+// last 32KiB, so the dictionary is never larger than that
+func (d *DeflateCompressionHandler) TrainDictionary(samples [][]byte, size int) ([]byte, error) {
+	return trainDeflateDictionary(samples, size)
+}
+
+// WithDictionary implements DictionaryCompressionHandler.
+func (d *DeflateCompressionHandler) WithDictionary(dictionary []byte) (CompressionHandler, error) {
+	return &DeflateCompressionHandler{Level: d.Level, dictionary: dictionary}, nil
+}
+
+func trainDeflateDictionary(samples [][]byte, size int) ([]byte, error) {
+	dictionary := trainDictionaryContent(samples, min(size, DEFLATE_MAX_DICTIONARY_SIZE))
+	if len(dictionary) == 0 {
+		return nil, errors.New("samples have too little in common to train a dictionary")
+	}
+	return dictionary, nil
+}
+
+// DEFLATE with the zlib header and adler-32 checksum
+type ZlibCompressionHandler struct {
+	Level      int
+	dictionary []byte
+}
+
+// Flag implements CompressionHandler.
+func (z *ZlibCompressionHandler) Flag() CompressionFlag {
+	return COMPRESSION_ZLIB
+}
+
+func (z *ZlibCompressionHandler) Decompress(compressed []byte) ([]byte, error) {
+	return decompressBytes(z, compressed)
+}
+
+func (z *ZlibCompressionHandler) Compress(uncompressed []byte) ([]byte, error) {
+	return compressBytes(z, uncompressed)
+}
+
+func (z *ZlibCompressionHandler) DecompressReader(compressed io.Reader) (io.ReadCloser, error) {
+	reader, err := zlib.NewReaderDict(compressed, z.dictionary)
+	if err != nil {
+		return nil, fmt.Errorf("error creating zlib decompressor: %w", err)
+	}
+	return reader, nil
+}
+
+func (z *ZlibCompressionHandler) CompressWriter(output io.Writer) (io.WriteCloser, error) {
+	writer, err := zlib.NewWriterLevelDict(output, deflateLevel(z.Level), z.dictionary)
+	if err != nil {
+		return nil, fmt.Errorf("error creating zlib compressor: %w", err)
+	}
+	return writer, nil
+}
+
+// TrainDictionary implements DictionaryCompressionHandler, the same as for raw DEFLATE.
+func (z *ZlibCompressionHandler) TrainDictionary(samples [][]byte, size int) ([]byte, error) {
+	return trainDeflateDictionary(samples, size)
+}
+
+// WithDictionary implements DictionaryCompressionHandler.
+func (z *ZlibCompressionHandler) WithDictionary(dictionary []byte) (CompressionHandler, error) {
+	return &ZlibCompressionHandler{Level: z.Level, dictionary: dictionary}, nil
+}
+
+// DEFLATE with the gzip header and crc-32 checksum
+type GzipCompressionHandler struct {
+	Level int
+}
+
+// Flag implements CompressionHandler.
+func (g *GzipCompressionHandler) Flag() CompressionFlag {
+	return COMPRESSION_GZIP
+}
+
+func (g *GzipCompressionHandler) Decompress(compressed []byte) ([]byte, error) {
+	return decompressBytes(g, compressed)
+}
+
+func (g *GzipCompressionHandler) Compress(uncompressed []byte) ([]byte, error) {
+	return compressBytes(g, uncompressed)
+}
+
+func (g *GzipCompressionHandler) DecompressReader(compressed io.Reader) (io.ReadCloser, error) {
+	reader, err := gzip.NewReader(compressed)
+	if err != nil {
+		return nil, fmt.Errorf("error creating gzip decompressor: %w", err)
+	}
+	// chunks are always a single gzip member
+	reader.Multistream(false)
+	return reader, nil
+}
+
+func (g *GzipCompressionHandler) CompressWriter(output io.Writer) (io.WriteCloser, error) {
+	writer, err := gzip.NewWriterLevel(output, deflateLevel(g.Level))
+	if err != nil {
+		return nil, fmt.Errorf("error creating gzip compressor: %w", err)
+	}
+	return writer, nil
+}
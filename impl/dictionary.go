@@ -0,0 +1,120 @@
+package jpkg_impl
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"slices"
+)
+
+// compression handlers that can share a dictionary between everything they compress
+type DictionaryCompressionHandler interface {
+	CompressionHandler
+	// builds a dictionary of at most size bytes from samples of the data to compress
+	TrainDictionary(samples [][]byte, size int) ([]byte, error)
+	// a copy of the handler that compresses and decompresses with the dictionary
+	WithDictionary(dictionary []byte) (CompressionHandler, error)
+}
+
+const (
+	dictionarySegmentSize = 64
+	dictionaryDmerSize    = 8
+	dictionaryTableBits   = 20
+)
+
+type dictionarySegment struct {
+	data  []byte
+	score uint64
+}
+
+type dictionarySegmentHeap []dictionarySegment
+
+func (h dictionarySegmentHeap) Len() int           { return len(h) }
+func (h dictionarySegmentHeap) Less(i, j int) bool { return h[i].score > h[j].score }
+func (h dictionarySegmentHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *dictionarySegmentHeap) Push(x any)        { *h = append(*h, x.(dictionarySegment)) }
+func (h *dictionarySegmentHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+func dictionaryDmerHash(dmer []byte) uint64 {
+	return (binary.LittleEndian.Uint64(dmer) * 0x9E3779B97F4A7C15) >> (64 - dictionaryTableBits)
+}
+
+// picks the segments of the samples whose 8 byte substrings appear in the most samples,
+// greedily and skipping substrings already picked, in the style of zstd's COVER trainer.
+// the best segments go last, where they're the cheapest to reference
+func trainDictionaryContent(samples [][]byte, size int) []byte {
+	// how many samples each substring appears in, by hash
+	frequency := make([]uint32, 1<<dictionaryTableBits)
+	// the last sample or segment a substring was counted in, so each is only counted once
+	seen := make([]uint32, 1<<dictionaryTableBits)
+	picked := make([]bool, 1<<dictionaryTableBits)
+	epoch := uint32(0)
+
+	for _, sample := range samples {
+		epoch++
+		for i := 0; i+dictionaryDmerSize <= len(sample); i++ {
+			h := dictionaryDmerHash(sample[i:])
+			if seen[h] != epoch {
+				seen[h] = epoch
+				frequency[h]++
+			}
+		}
+	}
+
+	score := func(segment []byte) uint64 {
+		epoch++
+		total := uint64(0)
+		for i := 0; i+dictionaryDmerSize <= len(segment); i++ {
+			h := dictionaryDmerHash(segment[i:])
+			if seen[h] != epoch && !picked[h] && frequency[h] > 1 {
+				total += uint64(frequency[h])
+			}
+			seen[h] = epoch
+		}
+		return total
+	}
+
+	segments := dictionarySegmentHeap{}
+	for _, sample := range samples {
+		for start := 0; start+dictionaryDmerSize <= len(sample); start += dictionarySegmentSize {
+			segment := sample[start:min(start+dictionarySegmentSize, len(sample))]
+			if s := score(segment); s > 0 {
+				segments = append(segments, dictionarySegment{segment, s})
+			}
+		}
+	}
+	heap.Init(&segments)
+
+	chosen := [][]byte{}
+	total := 0
+
+	for segments.Len() > 0 && total < size {
+		best := heap.Pop(&segments).(dictionarySegment)
+
+		// scores only go down as substrings are picked, so a segment that still
+		// beats the next best after rescoring is the best overall
+		best.score = score(best.data)
+		if best.score == 0 {
+			continue
+		}
+		if segments.Len() > 0 && best.score < segments[0].score {
+			heap.Push(&segments, best)
+			continue
+		}
+
+		for i := 0; i+dictionaryDmerSize <= len(best.data); i++ {
+			picked[dictionaryDmerHash(best.data[i:])] = true
+		}
+
+		segment := best.data[:min(len(best.data), size-total)]
+		chosen = append(chosen, segment)
+		total += len(segment)
+	}
+
+	slices.Reverse(chosen)
+	return slices.Concat(chosen...)
+}
@@ -1,10 +1,20 @@
 package jpkg_impl
 
 import (
+	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
+	"sync"
+
+	jpkg_bin "github.com/j4d3blooded/JPkg/bin"
 )
 
 type EncryptionFlag uint8
@@ -12,12 +22,20 @@ type EncryptionFlag uint8
 const (
 	ENCRYPTION_NONE EncryptionFlag = iota
 	ENCRYPTION_AES
+	ENCRYPTION_AES_PASSPHRASE
+	ENCRYPTION_X25519
 )
 
+var ErrAuthenticationFailed = errors.New("ciphertext failed authentication")
+
+// associatedData is authenticated but not encrypted, decryption fails with
+// ErrAuthenticationFailed unless it matches what was given to Encrypt
 type EncryptionHandler interface {
 	Flag() EncryptionFlag
-	Decrypt(output io.Writer) (io.WriteCloser, error)
-	Encrypt(output io.Writer) (io.WriteCloser, error)
+	Decrypt(output io.Writer, associatedData []byte) (io.WriteCloser, error)
+	Encrypt(output io.Writer, associatedData []byte) (io.WriteCloser, error)
+	// random access decryption of size bytes of ciphertext, returns the plaintext and its size
+	DecryptAt(input io.ReaderAt, size int64, associatedData []byte) (io.ReaderAt, int64, error)
 }
 
 type NullEncryptionHandler struct {
@@ -27,70 +45,369 @@ func (n *NullEncryptionHandler) Flag() EncryptionFlag {
 	return ENCRYPTION_NONE
 }
 
-func (n *NullEncryptionHandler) Decrypt(output io.Writer) (io.WriteCloser, error) {
-	return n.Encrypt(output)
+func (n *NullEncryptionHandler) Decrypt(output io.Writer, associatedData []byte) (io.WriteCloser, error) {
+	return n.Encrypt(output, associatedData)
 }
 
-func (n *NullEncryptionHandler) Encrypt(output io.Writer) (io.WriteCloser, error) {
+func (n *NullEncryptionHandler) Encrypt(output io.Writer, associatedData []byte) (io.WriteCloser, error) {
 	if wc, isWC := output.(io.WriteCloser); isWC {
 		return wc, nil
 	}
 	return newNopWriterCloser(output), nil
 }
 
+func (n *NullEncryptionHandler) DecryptAt(input io.ReaderAt, size int64, associatedData []byte) (io.ReaderAt, int64, error) {
+	return input, size, nil
+}
+
+// AES-GCM in a STREAM construction. every stream starts with a random salt that
+// derives its own key from Key, then the plaintext is sealed in AES_SEGMENT_SIZE
+// segments. segment nonces are the segment's counter followed by a flag marking
+// the final segment, so segments can't be reordered, dropped or truncated.
+// packages store a key check value, so a wrong key is caught before any file is opened
 type AESEncryptionHandler struct {
 	Key []byte
 }
 
-type aesEncryptor struct {
-	aead cipher.AEAD
-	w    io.Writer
+type aesParameters struct {
+	KeyCheck []byte
 }
 
-type aesDecryptor struct {
-	aead cipher.AEAD
-	w    io.Writer
+const (
+	AES_SEGMENT_SIZE = 64 * 1024
+	aesSaltSize      = 16
+	aesTagSize       = 16
+	aesSealedSize    = AES_SEGMENT_SIZE + aesTagSize
+)
+
+func (n *AESEncryptionHandler) Flag() EncryptionFlag {
+	return ENCRYPTION_AES
 }
 
+func (n *AESEncryptionHandler) Parameters() ([]byte, error) {
+	keyCheck, err := n.keyCheck()
+	if err != nil {
+		return nil, err
+	}
+
+	b := bytes.Buffer{}
+	if err := jpkg_bin.BinaryWrite(&b, aesParameters{keyCheck}); err != nil {
+		return nil, fmt.Errorf("error writing AES parameters: %w", err)
+	}
+
+	return b.Bytes(), nil
+}
+
+func (n *AESEncryptionHandler) LoadParameters(parameters []byte) error {
+	params, err := jpkg_bin.BinaryRead[aesParameters](bytes.NewReader(parameters))
+	if err != nil {
+		return fmt.Errorf("error reading AES parameters: %w", err)
+	}
+
+	keyCheck, err := n.keyCheck()
+	if err != nil {
+		return err
+	}
+
+	if !hmac.Equal(keyCheck, params.KeyCheck) {
+		return ErrWrongKey
+	}
+
+	return nil
+}
+
+func (n *AESEncryptionHandler) checkKeySize() error {
+	switch len(n.Key) {
+	case 16, 24, 32:
+		return nil
+	case 0:
+		return fmt.Errorf("%w: no key given", ErrWrongKey)
+	default:
+		return fmt.Errorf("%w: invalid AES key size: %v", ErrWrongKey, len(n.Key))
+	}
+}
+
+// the key check value of a key derived from Key for nothing else, so it says nothing
+// about the stream keys
+func (n *AESEncryptionHandler) keyCheck() ([]byte, error) {
+	if err := n.checkKeySize(); err != nil {
+		return nil, err
+	}
+
+	key, err := hkdf.Key(sha256.New, n.Key, nil, "jpkg aes key check", 32)
+	if err != nil {
+		return nil, fmt.Errorf("error deriving key check key: %w", err)
+	}
+
+	return keyCheckValue(key), nil
+}
+
+func (n *AESEncryptionHandler) streamCipher(salt []byte) (cipher.AEAD, error) {
+	if err := n.checkKeySize(); err != nil {
+		return nil, err
+	}
+
+	key, err := hkdf.Key(sha256.New, n.Key, salt, "jpkg aes stream", len(n.Key))
+	if err != nil {
+		return nil, fmt.Errorf("error deriving stream key: %w", err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("error creating AES cipher: %w", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("error creating GCM: %w", err)
+	}
+
+	return aead, nil
+}
+
+func aesSegmentNonce(counter uint64, last bool) ([]byte, error) {
+	if counter > 0xFFFFFFFF {
+		return nil, errors.New("stream has too many segments")
+	}
+
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint32(nonce[7:11], uint32(counter))
+	if last {
+		nonce[11] = 1
+	}
+	return nonce, nil
+}
+
+func sealSegment(aead cipher.AEAD, plaintext []byte, counter uint64, last bool, associatedData []byte) ([]byte, error) {
+	nonce, err := aesSegmentNonce(counter, last)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nil, nonce, plaintext, associatedData), nil
+}
+
+func openSegment(aead cipher.AEAD, ciphertext []byte, counter uint64, last bool, associatedData []byte) ([]byte, error) {
+	nonce, err := aesSegmentNonce(counter, last)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, nonce, ciphertext, associatedData)
+	if err != nil {
+		return nil, fmt.Errorf("%w: segment %v", ErrAuthenticationFailed, counter)
+	}
+	return plaintext, nil
+}
+
+type aesEncryptor struct {
+	aead           cipher.AEAD
+	w              io.Writer
+	buffer         []byte
+	counter        uint64
+	associatedData []byte
+}
+
+// a full segment is only sealed once more data arrives, as the last segment is sealed differently
 func (a *aesEncryptor) Write(p []byte) (n int, err error) {
 	n = len(p)
-	b := a.aead.Seal(p[:0], nil, p, nil)
-	_, err = a.w.Write(b)
+	for len(p) > 0 {
+		if len(a.buffer) == AES_SEGMENT_SIZE {
+			if err = a.seal(false); err != nil {
+				return
+			}
+		}
+		taken := min(AES_SEGMENT_SIZE-len(a.buffer), len(p))
+		a.buffer = append(a.buffer, p[:taken]...)
+		p = p[taken:]
+	}
 	return
 }
 
+func (a *aesEncryptor) seal(last bool) error {
+	sealed, err := sealSegment(a.aead, a.buffer, a.counter, last, a.associatedData)
+	if err != nil {
+		return err
+	}
+	if _, err := a.w.Write(sealed); err != nil {
+		return err
+	}
+	a.buffer = a.buffer[:0]
+	a.counter++
+	return nil
+}
+
+func (a *aesEncryptor) Close() error {
+	return a.seal(true)
+}
+
+type aesDecryptor struct {
+	handler        *AESEncryptionHandler
+	aead           cipher.AEAD
+	w              io.Writer
+	buffer         []byte
+	counter        uint64
+	associatedData []byte
+}
+
+// segments are only opened once more ciphertext arrives after them, the rest is opened on Close
 func (a *aesDecryptor) Write(p []byte) (n int, err error) {
 	n = len(p)
-	b, err := a.aead.Open(p[:0], nil, p, nil)
-	if err != nil {
-		err = fmt.Errorf("error during aes decryption: %w", err)
-		return
+	a.buffer = append(a.buffer, p...)
+
+	if a.aead == nil {
+		if len(a.buffer) < aesSaltSize {
+			return
+		}
+		if a.aead, err = a.handler.streamCipher(a.buffer[:aesSaltSize]); err != nil {
+			return
+		}
+		a.buffer = a.buffer[aesSaltSize:]
+	}
+
+	for len(a.buffer) > aesSealedSize {
+		if err = a.open(a.buffer[:aesSealedSize], false); err != nil {
+			return
+		}
+		a.buffer = a.buffer[aesSealedSize:]
 	}
-	_, err = a.w.Write(b)
 	return
 }
 
-func (a *aesEncryptor) Close() error {
+func (a *aesDecryptor) open(sealed []byte, last bool) error {
+	plaintext, err := openSegment(a.aead, sealed, a.counter, last, a.associatedData)
+	if err != nil {
+		return err
+	}
+	if _, err := a.w.Write(plaintext); err != nil {
+		return err
+	}
+	a.counter++
 	return nil
 }
 
-func (n *AESEncryptionHandler) Flag() EncryptionFlag {
-	return ENCRYPTION_AES
+func (a *aesDecryptor) Close() error {
+	if a.aead == nil {
+		return fmt.Errorf("%w: stream is truncated", ErrAuthenticationFailed)
+	}
+	return a.open(a.buffer, true)
+}
+
+func (n *AESEncryptionHandler) Decrypt(output io.Writer, associatedData []byte) (io.WriteCloser, error) {
+	return &aesDecryptor{handler: n, w: output, associatedData: associatedData}, nil
 }
 
-func (n *AESEncryptionHandler) Decrypt(output io.Writer) (io.WriteCloser, error) {
-	return n.Encrypt(output)
+func (n *AESEncryptionHandler) Encrypt(output io.Writer, associatedData []byte) (io.WriteCloser, error) {
+	salt := make([]byte, aesSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("error generating salt: %w", err)
+	}
+
+	aead, err := n.streamCipher(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := output.Write(salt); err != nil {
+		return nil, fmt.Errorf("error writing salt: %w", err)
+	}
+
+	return &aesEncryptor{
+		aead:           aead,
+		w:              output,
+		buffer:         make([]byte, 0, AES_SEGMENT_SIZE),
+		associatedData: associatedData,
+	}, nil
 }
 
-func (n *AESEncryptionHandler) Encrypt(output io.Writer) (io.WriteCloser, error) {
-	block, err := aes.NewCipher(n.Key)
+func (n *AESEncryptionHandler) DecryptAt(input io.ReaderAt, size int64, associatedData []byte) (io.ReaderAt, int64, error) {
+	body := size - aesSaltSize
+	if body < aesTagSize {
+		return nil, 0, fmt.Errorf("%w: stream is truncated", ErrAuthenticationFailed)
+	}
+
+	segments := (body + aesSealedSize - 1) / aesSealedSize
+	if body-(segments-1)*aesSealedSize < aesTagSize {
+		return nil, 0, fmt.Errorf("%w: stream is truncated", ErrAuthenticationFailed)
+	}
+
+	salt := make([]byte, aesSaltSize)
+	if _, err := input.ReadAt(salt, 0); err != nil {
+		return nil, 0, fmt.Errorf("error reading salt: %w", err)
+	}
+
+	aead, err := n.streamCipher(salt)
 	if err != nil {
-		return nil, fmt.Errorf("error creating AES cipher: %w", err)
+		return nil, 0, err
+	}
+
+	reader := &aesSegmentReader{
+		aead:           aead,
+		input:          input,
+		size:           size,
+		segments:       segments,
+		cached:         -1,
+		associatedData: associatedData,
 	}
-	aead, err := cipher.NewGCMWithRandomNonce(block)
+
+	return reader, body - segments*aesTagSize, nil
+}
+
+// decrypts only the segments a read touches, and keeps the last one around
+type aesSegmentReader struct {
+	aead           cipher.AEAD
+	input          io.ReaderAt
+	size           int64
+	segments       int64
+	lock           sync.Mutex
+	cached         int64
+	segment        []byte
+	associatedData []byte
+}
+
+func (a *aesSegmentReader) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for n < len(p) {
+		idx := off / AES_SEGMENT_SIZE
+		if off < 0 || idx >= a.segments {
+			return n, io.EOF
+		}
+
+		segment, err := a.getSegment(idx)
+		if err != nil {
+			return n, err
+		}
+
+		start := off - idx*AES_SEGMENT_SIZE
+		if start >= int64(len(segment)) {
+			return n, io.EOF
+		}
+
+		copied := copy(p[n:], segment[start:])
+		n += copied
+		off += int64(copied)
+	}
+	return n, nil
+}
+
+func (a *aesSegmentReader) getSegment(idx int64) ([]byte, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if idx == a.cached {
+		return a.segment, nil
+	}
+
+	start := aesSaltSize + idx*aesSealedSize
+	sealed := make([]byte, min(aesSealedSize, a.size-start))
+	if _, err := a.input.ReadAt(sealed, start); err != nil {
+		return nil, fmt.Errorf("error reading segment %v: %w", idx, err)
+	}
+
+	segment, err := openSegment(a.aead, sealed, uint64(idx), idx == a.segments-1, a.associatedData)
 	if err != nil {
-		return nil, fmt.Errorf("error creating cipher thingy: %w", err)
+		return nil, err
 	}
 
-	return &aesEncryptor{aead, output}, nil
+	a.cached = idx
+	a.segment = segment
+	return segment, nil
 }
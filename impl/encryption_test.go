@@ -0,0 +1,142 @@
+package jpkg_impl
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	jpkg_bin "github.com/j4d3blooded/JPkg/bin"
+)
+
+func encryptTestData(t *testing.T, handler EncryptionHandler, plaintext []byte) []byte {
+	b := bytes.Buffer{}
+
+	encryptor, err := handler.Encrypt(&b, []byte("associated"))
+	if err != nil {
+		t.Logf("error creating encryptor: %v", err.Error())
+		t.FailNow()
+	}
+
+	// uneven writes so segment boundaries don't line up with them
+	for len(plaintext) > 0 {
+		n := min(len(plaintext), 10007)
+		if _, err := encryptor.Write(plaintext[:n]); err != nil {
+			t.Logf("error encrypting: %v", err.Error())
+			t.FailNow()
+		}
+		plaintext = plaintext[n:]
+	}
+
+	if err := encryptor.Close(); err != nil {
+		t.Logf("error closing encryptor: %v", err.Error())
+		t.FailNow()
+	}
+
+	return b.Bytes()
+}
+
+func TestAESRoundTrip(t *testing.T) {
+	handler := &AESEncryptionHandler{Key: bytes.Repeat([]byte{7}, 32)}
+
+	for _, size := range []int{0, 1, AES_SEGMENT_SIZE, AES_SEGMENT_SIZE + 1, 3*AES_SEGMENT_SIZE + 17} {
+		plaintext := make([]byte, size)
+		for i := range plaintext {
+			plaintext[i] = byte(i * 31)
+		}
+
+		ciphertext := encryptTestData(t, handler, plaintext)
+
+		decrypted := bytes.Buffer{}
+		decryptor, err := handler.Decrypt(&decrypted, []byte("associated"))
+		if err != nil {
+			t.Logf("error creating decryptor: %v", err.Error())
+			t.FailNow()
+		}
+		// copy in odd sized pieces, decryption mustn't depend on how the ciphertext is written
+		if _, err := io.CopyBuffer(decryptor, bytes.NewReader(ciphertext), make([]byte, 999)); err != nil {
+			t.Logf("error decrypting %v bytes: %v", size, err.Error())
+			t.FailNow()
+		}
+		if err := decryptor.Close(); err != nil {
+			t.Logf("error closing decryptor for %v bytes: %v", size, err.Error())
+			t.FailNow()
+		}
+		if !bytes.Equal(decrypted.Bytes(), plaintext) {
+			t.Logf("%v bytes incorrectly round tripped", size)
+			t.FailNow()
+		}
+
+		reader, plaintextSize, err := handler.DecryptAt(bytes.NewReader(ciphertext), int64(len(ciphertext)), []byte("associated"))
+		if err != nil || plaintextSize != int64(size) {
+			t.Logf("error opening %v bytes for random access: %v, size %v", size, err, plaintextSize)
+			t.FailNow()
+		}
+		if size > 20 {
+			middle := make([]byte, 20)
+			if _, err := reader.ReadAt(middle, int64(size-20)); err != nil || !bytes.Equal(middle, plaintext[size-20:]) {
+				t.Logf("random access read of %v bytes incorrect: %v", size, err)
+				t.FailNow()
+			}
+		}
+	}
+}
+
+func TestAESDetectsTampering(t *testing.T) {
+	handler := &AESEncryptionHandler{Key: bytes.Repeat([]byte{7}, 16)}
+	ciphertext := encryptTestData(t, handler, make([]byte, 3*AES_SEGMENT_SIZE))
+
+	truncated := ciphertext[:aesSaltSize+2*aesSealedSize]
+
+	reordered := bytes.Clone(ciphertext)
+	copy(reordered[aesSaltSize:], ciphertext[aesSaltSize+aesSealedSize:aesSaltSize+2*aesSealedSize])
+	copy(reordered[aesSaltSize+aesSealedSize:], ciphertext[aesSaltSize:aesSaltSize+aesSealedSize])
+
+	for name, tampered := range map[string][]byte{"truncated": truncated, "reordered": reordered} {
+		reader, size, err := handler.DecryptAt(bytes.NewReader(tampered), int64(len(tampered)), []byte("associated"))
+		if err == nil {
+			_, err = io.ReadAll(io.NewSectionReader(reader, 0, size))
+		}
+		if !errors.Is(err, ErrAuthenticationFailed) {
+			t.Logf("%v ciphertext should fail authentication: %v", name, err)
+			t.FailNow()
+		}
+	}
+
+	reader, size, err := handler.DecryptAt(bytes.NewReader(ciphertext), int64(len(ciphertext)), []byte("transplanted"))
+	if err == nil {
+		_, err = io.ReadAll(io.NewSectionReader(reader, 0, size))
+	}
+	if !errors.Is(err, ErrAuthenticationFailed) {
+		t.Logf("ciphertext with different associated data should fail authentication: %v", err)
+		t.FailNow()
+	}
+}
+
+func TestMalformedX25519Key(t *testing.T) {
+	if _, err := GetEncryptionHandler(ENCRYPTION_X25519, []byte("too short")); !errors.Is(err, ErrWrongKey) {
+		t.Logf("malformed X25519 key should fail with ErrWrongKey: %v", err)
+		t.FailNow()
+	}
+
+	if _, err := GetEncryptionHandler(ENCRYPTION_X25519, nil); err != nil {
+		t.Logf("missing key should only fail once the parameters load: %v", err.Error())
+		t.FailNow()
+	}
+}
+
+func TestPBKDF2IterationLimit(t *testing.T) {
+	handler := &PassphraseEncryptionHandler{Passphrase: []byte("correct horse"), Iterations: MAX_PBKDF2_ITERATIONS + 1}
+	if _, err := handler.Parameters(); err == nil {
+		t.Logf("expected more than MAX_PBKDF2_ITERATIONS to be refused when encoding")
+		t.FailNow()
+	}
+
+	b := bytes.Buffer{}
+	jpkg_bin.BinaryWrite(&b, passphraseParameters{KDF: KDF_PBKDF2_SHA256, Iterations: MAX_PBKDF2_ITERATIONS + 1, Salt: make([]byte, 16)})
+
+	if err := handler.LoadParameters(b.Bytes()); !errors.Is(err, jpkg_bin.ErrLimitExceeded) {
+		t.Logf("expected more than MAX_PBKDF2_ITERATIONS to be rejected when reading: %v", err)
+		t.FailNow()
+	}
+}
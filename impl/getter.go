@@ -1,43 +1,148 @@
 package jpkg_impl
 
-import "fmt"
+import (
+	"crypto/ecdh"
+	"errors"
+	"fmt"
+	"sync"
+)
 
-func GetCompressionHandler(flag CompressionFlag) CompressionHandler {
-	switch flag {
-	case COMPRESSION_NONE:
-		return &NullCompressionHandler{}
-	case COMPRESSION_LZW:
-		return &LZWCompressionHandler{}
+// a flag that no registered handler reads, from a corrupt package or one written
+// with handlers this reader doesn't have
+var ErrUnsupportedFeature = errors.New("unsupported feature")
+
+// flags from VENDOR_FLAG_MIN upwards are never assigned by the standard, and are free
+// for private handlers
+const VENDOR_FLAG_MIN = 0xC0
+
+type CompressionFactory func() CompressionHandler
+type EncryptionFactory func(key []byte) (EncryptionHandler, error)
+type CryptoFactory func(publicKey []byte) CryptoHandler
+type HasherFactory func() HasherHandler
+
+var (
+	registryLock sync.RWMutex
+
+	compressionFactories = map[CompressionFlag]CompressionFactory{
+		COMPRESSION_NONE:    func() CompressionHandler { return &NullCompressionHandler{} },
+		COMPRESSION_LZW:     func() CompressionHandler { return &LZWCompressionHandler{} },
+		COMPRESSION_DEFLATE: func() CompressionHandler { return &DeflateCompressionHandler{} },
+		COMPRESSION_ZLIB:    func() CompressionHandler { return &ZlibCompressionHandler{} },
+		COMPRESSION_GZIP:    func() CompressionHandler { return &GzipCompressionHandler{} },
+		COMPRESSION_ZSTD:    func() CompressionHandler { return &ZstdCompressionHandler{} },
+		COMPRESSION_LZ4:     func() CompressionHandler { return &LZ4CompressionHandler{} },
+		COMPRESSION_XZ:      func() CompressionHandler { return &XZCompressionHandler{} },
+	}
+
+	encryptionFactories = map[EncryptionFlag]EncryptionFactory{
+		ENCRYPTION_NONE:           func(key []byte) (EncryptionHandler, error) { return &NullEncryptionHandler{}, nil },
+		ENCRYPTION_AES:            func(key []byte) (EncryptionHandler, error) { return &AESEncryptionHandler{key}, nil },
+		ENCRYPTION_AES_PASSPHRASE: func(key []byte) (EncryptionHandler, error) { return &PassphraseEncryptionHandler{Passphrase: key}, nil },
+		ENCRYPTION_X25519: func(key []byte) (EncryptionHandler, error) {
+			// without a key the package can still be opened, and fails when the parameters load
+			if len(key) == 0 {
+				return &RecipientsEncryptionHandler{}, nil
+			}
+			privateKey, err := ecdh.X25519().NewPrivateKey(key)
+			if err != nil {
+				return nil, fmt.Errorf("%w: invalid X25519 private key: %w", ErrWrongKey, err)
+			}
+			return &RecipientsEncryptionHandler{PrivateKey: privateKey}, nil
+		},
+	}
+
+	cryptoFactories = map[CryptoFlag]CryptoFactory{
+		CRYPTO_NONE:    func(publicKey []byte) CryptoHandler { return &NullCryptoHandler{} },
+		CRYPTO_ED25519: func(publicKey []byte) CryptoHandler { return &Ed25519CryptoHandler{PublicKey: publicKey} },
+	}
+
+	hasherFactories = map[HasherFlag]HasherFactory{
+		HASHER_NONE:     func() HasherHandler { return &NullHasherHandler{} },
+		HASHER_SHA256:   func() HasherHandler { return &SHA256HasherHandler{} },
+		HASHER_SHA512:   func() HasherHandler { return &SHA512HasherHandler{} },
+		HASHER_SHA3_256: func() HasherHandler { return &SHA3_256HasherHandler{} },
+	}
+)
+
+func register[F ~uint8, T any](factories map[F]T, flag F, factory T) error {
+	if flag < VENDOR_FLAG_MIN {
+		return fmt.Errorf("flag %v is reserved by the standard, vendor flags start at %v", flag, VENDOR_FLAG_MIN)
+	}
+
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	if _, exists := factories[flag]; exists {
+		return fmt.Errorf("flag %v is already registered", flag)
 	}
 
-	panic(fmt.Errorf("invalid compression flag: %v", flag))
+	factories[flag] = factory
+	return nil
 }
 
-func GetEncryptionHandler(flag EncryptionFlag, key []byte) EncryptionHandler {
-	switch flag {
-	case ENCRYPTION_NONE:
-		return &NullEncryptionHandler{}
-	case ENCRYPTION_AES:
-		return &AESEncryptionHandler{key}
+func lookup[F ~uint8, T any](factories map[F]T, kind string, flag F) (T, error) {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	factory, exists := factories[flag]
+	if !exists {
+		return factory, fmt.Errorf("%w: %v flag %v", ErrUnsupportedFeature, kind, flag)
 	}
 
-	panic(fmt.Errorf("invalid encryption flag: %v", flag))
+	return factory, nil
+}
+
+// RegisterCompression makes packages using flag readable with the handlers factory returns.
+// flags must be at least VENDOR_FLAG_MIN, and can only be registered once
+func RegisterCompression(flag CompressionFlag, factory CompressionFactory) error {
+	return register(compressionFactories, flag, factory)
+}
+
+// RegisterEncryption makes packages using flag readable with the handlers factory returns,
+// which are given the key from the reader options
+func RegisterEncryption(flag EncryptionFlag, factory EncryptionFactory) error {
+	return register(encryptionFactories, flag, factory)
+}
+
+// RegisterCrypto makes packages signed with flag readable with the handlers factory returns,
+// which are given the public key from the reader options
+func RegisterCrypto(flag CryptoFlag, factory CryptoFactory) error {
+	return register(cryptoFactories, flag, factory)
+}
+
+// RegisterHasher makes packages hashed with flag readable with the handlers factory returns
+func RegisterHasher(flag HasherFlag, factory HasherFactory) error {
+	return register(hasherFactories, flag, factory)
 }
 
-func GetCryptoHandler(flag CryptoFlag) CryptoHandler {
-	switch flag {
-	case CRYPTO_NONE:
-		return &NullCryptoHandler{}
+func GetCompressionHandler(flag CompressionFlag) (CompressionHandler, error) {
+	factory, err := lookup(compressionFactories, "compression", flag)
+	if err != nil {
+		return nil, err
 	}
+	return factory(), nil
+}
 
-	panic(fmt.Errorf("invalid crypto flag: %v", flag))
+func GetEncryptionHandler(flag EncryptionFlag, key []byte) (EncryptionHandler, error) {
+	factory, err := lookup(encryptionFactories, "encryption", flag)
+	if err != nil {
+		return nil, err
+	}
+	return factory(key)
 }
 
-func GetHashHandler(flag HasherFlag) HasherHandler {
-	switch flag {
-	case HASHER_NONE:
-		return &NullHasherHandler{}
+func GetCryptoHandler(flag CryptoFlag, publicKey []byte) (CryptoHandler, error) {
+	factory, err := lookup(cryptoFactories, "crypto", flag)
+	if err != nil {
+		return nil, err
 	}
+	return factory(publicKey), nil
+}
 
-	panic(fmt.Errorf("invalid hash flag: %v", flag))
+func GetHashHandler(flag HasherFlag) (HasherHandler, error) {
+	factory, err := lookup(hasherFactories, "hash", flag)
+	if err != nil {
+		return nil, err
+	}
+	return factory(), nil
 }
@@ -1,13 +1,25 @@
 package jpkg_impl
 
+import (
+	"crypto/sha256"
+	"crypto/sha3"
+	"crypto/sha512"
+	"hash"
+)
+
 type HasherFlag uint8
 
 const (
 	HASHER_NONE HasherFlag = iota
+	HASHER_SHA256
+	HASHER_SHA512
+	HASHER_SHA3_256
 )
 
 type HasherHandler interface {
 	Flag() HasherFlag
+	Size() int
+	New() hash.Hash
 }
 
 type NullHasherHandler struct {
@@ -16,3 +28,56 @@ type NullHasherHandler struct {
 func (n *NullHasherHandler) Flag() HasherFlag {
 	return HASHER_NONE
 }
+
+func (n *NullHasherHandler) Size() int {
+	return 0
+}
+
+func (n *NullHasherHandler) New() hash.Hash {
+	return nil
+}
+
+type SHA256HasherHandler struct {
+}
+
+func (s *SHA256HasherHandler) Flag() HasherFlag {
+	return HASHER_SHA256
+}
+
+func (s *SHA256HasherHandler) Size() int {
+	return sha256.Size
+}
+
+func (s *SHA256HasherHandler) New() hash.Hash {
+	return sha256.New()
+}
+
+type SHA512HasherHandler struct {
+}
+
+func (s *SHA512HasherHandler) Flag() HasherFlag {
+	return HASHER_SHA512
+}
+
+func (s *SHA512HasherHandler) Size() int {
+	return sha512.Size
+}
+
+func (s *SHA512HasherHandler) New() hash.Hash {
+	return sha512.New()
+}
+
+type SHA3_256HasherHandler struct {
+}
+
+func (s *SHA3_256HasherHandler) Flag() HasherFlag {
+	return HASHER_SHA3_256
+}
+
+func (s *SHA3_256HasherHandler) Size() int {
+	return sha3.New256().Size()
+}
+
+func (s *SHA3_256HasherHandler) New() hash.Hash {
+	return sha3.New256()
+}
@@ -0,0 +1,55 @@
+package jpkg_impl
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/pierrec/lz4/v4"
+)
+
+// LZ4 frames, for packages where decompression speed matters more than ratio. level 0
+// uses the fast encoder, 1 to 9 use the slower high compression encoder, which
+// decompresses just as fast
+type LZ4CompressionHandler struct {
+	Level int
+}
+
+// Flag implements CompressionHandler.
+func (l *LZ4CompressionHandler) Flag() CompressionFlag {
+	return COMPRESSION_LZ4
+}
+
+func (l *LZ4CompressionHandler) Decompress(compressed []byte) ([]byte, error) {
+	return decompressBytes(l, compressed)
+}
+
+func (l *LZ4CompressionHandler) Compress(uncompressed []byte) ([]byte, error) {
+	return compressBytes(l, uncompressed)
+}
+
+func (l *LZ4CompressionHandler) DecompressReader(compressed io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(lz4.NewReader(compressed)), nil
+}
+
+func (l *LZ4CompressionHandler) CompressWriter(output io.Writer) (io.WriteCloser, error) {
+	if l.Level < 0 || l.Level > 9 {
+		return nil, fmt.Errorf("invalid lz4 level: %v", l.Level)
+	}
+
+	level := lz4.Fast
+	if l.Level != 0 {
+		level = lz4.CompressionLevel(1 << (8 + l.Level))
+	}
+
+	writer := lz4.NewWriter(output)
+	// blocks the size of a default chunk, rather than allocating 4MB buffers for every chunk
+	err := writer.Apply(
+		lz4.BlockSizeOption(lz4.Block64Kb),
+		lz4.CompressionLevelOption(level),
+		lz4.ConcurrencyOption(1),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error creating lz4 compressor: %w", err)
+	}
+	return writer, nil
+}
@@ -0,0 +1,160 @@
+package jpkg_impl
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+
+	jpkg_bin "github.com/j4d3blooded/JPkg/bin"
+)
+
+var ErrWrongKey = errors.New("wrong encryption key or passphrase")
+
+// handlers that keep parameters in the package, they're written as a sized blob
+// right after the header
+type ParameterizedEncryptionHandler interface {
+	EncryptionHandler
+	// called by the encoder before anything is encrypted
+	Parameters() ([]byte, error)
+	// called by the reader before anything is decrypted, fails with ErrWrongKey
+	// if the key doesn't match the one the package was written with
+	LoadParameters(parameters []byte) error
+}
+
+const (
+	KDF_PBKDF2_SHA256 uint8 = iota + 1
+)
+
+const DEFAULT_PBKDF2_ITERATIONS = 600_000
+
+// packages asking for more iterations are rejected when read, so they can't stall the reader
+// for more than about a second before the key check
+const MAX_PBKDF2_ITERATIONS = 1_000_000
+
+// derives an AES-256 key from a passphrase with PBKDF2-SHA256, the salt, iteration
+// count and a key check value are stored in the package so a wrong passphrase is
+// caught before any file is opened
+type PassphraseEncryptionHandler struct {
+	Passphrase []byte
+	// defaults to DEFAULT_PBKDF2_ITERATIONS when encoding
+	Iterations uint64
+	aes        *AESEncryptionHandler
+}
+
+type passphraseParameters struct {
+	KDF        uint8
+	Iterations uint64
+	Salt       []byte
+	KeyCheck   []byte
+}
+
+func (p *PassphraseEncryptionHandler) Flag() EncryptionFlag {
+	return ENCRYPTION_AES_PASSPHRASE
+}
+
+func (p *PassphraseEncryptionHandler) Parameters() ([]byte, error) {
+	params := passphraseParameters{
+		KDF:        KDF_PBKDF2_SHA256,
+		Iterations: p.Iterations,
+		Salt:       make([]byte, 16),
+	}
+
+	if params.Iterations == 0 {
+		params.Iterations = DEFAULT_PBKDF2_ITERATIONS
+	}
+
+	if params.Iterations > MAX_PBKDF2_ITERATIONS {
+		return nil, fmt.Errorf("%v key derivation iterations is more than readers allow (%v)", params.Iterations, MAX_PBKDF2_ITERATIONS)
+	}
+
+	if _, err := rand.Read(params.Salt); err != nil {
+		return nil, fmt.Errorf("error generating salt: %w", err)
+	}
+
+	key, err := p.deriveKey(params)
+	if err != nil {
+		return nil, err
+	}
+
+	params.KeyCheck = keyCheckValue(key)
+	p.aes = &AESEncryptionHandler{key}
+
+	b := bytes.Buffer{}
+	if err := jpkg_bin.BinaryWrite(&b, params); err != nil {
+		return nil, fmt.Errorf("error writing passphrase parameters: %w", err)
+	}
+
+	return b.Bytes(), nil
+}
+
+func (p *PassphraseEncryptionHandler) LoadParameters(parameters []byte) error {
+	params, err := jpkg_bin.BinaryRead[passphraseParameters](bytes.NewReader(parameters))
+	if err != nil {
+		return fmt.Errorf("error reading passphrase parameters: %w", err)
+	}
+
+	if params.Iterations > MAX_PBKDF2_ITERATIONS {
+		return fmt.Errorf("%w: %v key derivation iterations", jpkg_bin.ErrLimitExceeded, params.Iterations)
+	}
+
+	key, err := p.deriveKey(*params)
+	if err != nil {
+		return err
+	}
+
+	if !hmac.Equal(keyCheckValue(key), params.KeyCheck) {
+		return ErrWrongKey
+	}
+
+	p.aes = &AESEncryptionHandler{key}
+	return nil
+}
+
+func (p *PassphraseEncryptionHandler) deriveKey(params passphraseParameters) ([]byte, error) {
+	if params.KDF != KDF_PBKDF2_SHA256 {
+		return nil, fmt.Errorf("%w: key derivation function %v", ErrUnsupportedFeature, params.KDF)
+	}
+
+	if len(p.Passphrase) == 0 {
+		return nil, fmt.Errorf("%w: no passphrase given", ErrWrongKey)
+	}
+
+	key, err := pbkdf2.Key(sha256.New, string(p.Passphrase), params.Salt, int(params.Iterations), 32)
+	if err != nil {
+		return nil, fmt.Errorf("error deriving key: %w", err)
+	}
+
+	return key, nil
+}
+
+func keyCheckValue(key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("jpkg key check"))
+	return mac.Sum(nil)
+}
+
+func (p *PassphraseEncryptionHandler) Decrypt(output io.Writer, associatedData []byte) (io.WriteCloser, error) {
+	if p.aes == nil {
+		return nil, errors.New("passphrase parameters have not been loaded")
+	}
+	return p.aes.Decrypt(output, associatedData)
+}
+
+func (p *PassphraseEncryptionHandler) Encrypt(output io.Writer, associatedData []byte) (io.WriteCloser, error) {
+	if p.aes == nil {
+		return nil, errors.New("passphrase parameters have not been generated")
+	}
+	return p.aes.Encrypt(output, associatedData)
+}
+
+func (p *PassphraseEncryptionHandler) DecryptAt(input io.ReaderAt, size int64, associatedData []byte) (io.ReaderAt, int64, error) {
+	if p.aes == nil {
+		return nil, 0, errors.New("passphrase parameters have not been loaded")
+	}
+	return p.aes.DecryptAt(input, size, associatedData)
+}
@@ -0,0 +1,202 @@
+package jpkg_impl
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+
+	jpkg_bin "github.com/j4d3blooded/JPkg/bin"
+)
+
+// encrypts the package with a random content key, which is wrapped once for every
+// recipient with X25519 and HKDF-SHA256. PrivateKey is only needed for reading,
+// Recipients only for encoding
+type RecipientsEncryptionHandler struct {
+	Recipients []*ecdh.PublicKey
+	PrivateKey *ecdh.PrivateKey
+	contentKey []byte
+}
+
+type recipientTable struct {
+	Count uint64
+}
+
+type recipientSlot struct {
+	RecipientKey []byte
+	EphemeralKey []byte
+	WrappedKey   []byte
+}
+
+func (r *RecipientsEncryptionHandler) Flag() EncryptionFlag {
+	return ENCRYPTION_X25519
+}
+
+// generates a content key unless one was already loaded, so a package can be given
+// new recipients without its data being encrypted again
+func (r *RecipientsEncryptionHandler) Parameters() ([]byte, error) {
+	if len(r.Recipients) == 0 {
+		return nil, errors.New("package has no recipients")
+	}
+
+	if r.contentKey == nil {
+		r.contentKey = make([]byte, 32)
+		if _, err := rand.Read(r.contentKey); err != nil {
+			return nil, fmt.Errorf("error generating content key: %w", err)
+		}
+	}
+
+	b := bytes.Buffer{}
+	if err := jpkg_bin.BinaryWrite(&b, recipientTable{uint64(len(r.Recipients))}); err != nil {
+		return nil, fmt.Errorf("error writing recipient count: %w", err)
+	}
+
+	for i, recipient := range r.Recipients {
+		slot, err := r.wrapKey(recipient)
+		if err != nil {
+			return nil, fmt.Errorf("error wrapping key for recipient %v: %w", i, err)
+		}
+		if err := jpkg_bin.BinaryWrite(&b, *slot); err != nil {
+			return nil, fmt.Errorf("error writing recipient %v: %w", i, err)
+		}
+	}
+
+	return b.Bytes(), nil
+}
+
+func (r *RecipientsEncryptionHandler) LoadParameters(parameters []byte) error {
+	if r.PrivateKey == nil {
+		return fmt.Errorf("%w: no private key given", ErrWrongKey)
+	}
+
+	pr := bytes.NewReader(parameters)
+	count, err := jpkg_bin.BinaryRead[recipientTable](pr)
+	if err != nil {
+		return fmt.Errorf("error reading recipient count: %w", err)
+	}
+
+	publicKey := r.PrivateKey.PublicKey().Bytes()
+
+	for i := range count.Count {
+		slot, err := jpkg_bin.BinaryRead[recipientSlot](pr)
+		if err != nil {
+			return fmt.Errorf("error reading recipient %v: %w", i, err)
+		}
+
+		if !bytes.Equal(slot.RecipientKey, publicKey) {
+			continue
+		}
+
+		contentKey, err := r.unwrapKey(slot)
+		if err != nil {
+			return err
+		}
+
+		r.contentKey = contentKey
+		return nil
+	}
+
+	return fmt.Errorf("%w: private key is not a recipient of the package", ErrWrongKey)
+}
+
+func recipientWrapCipher(shared []byte, ephemeralKey []byte, recipientKey []byte) (cipher.AEAD, error) {
+	salt := append(bytes.Clone(ephemeralKey), recipientKey...)
+	wrapKey, err := hkdf.Key(sha256.New, shared, salt, "jpkg recipient", 32)
+	if err != nil {
+		return nil, fmt.Errorf("error deriving wrapping key: %w", err)
+	}
+
+	block, err := aes.NewCipher(wrapKey)
+	if err != nil {
+		return nil, fmt.Errorf("error creating AES cipher: %w", err)
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// every wrapping key comes from a fresh ephemeral key, so a zero nonce is never reused
+func (r *RecipientsEncryptionHandler) wrapKey(recipient *ecdh.PublicKey) (*recipientSlot, error) {
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("error generating ephemeral key: %w", err)
+	}
+
+	shared, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return nil, fmt.Errorf("error during key exchange: %w", err)
+	}
+
+	slot := &recipientSlot{
+		RecipientKey: recipient.Bytes(),
+		EphemeralKey: ephemeral.PublicKey().Bytes(),
+	}
+
+	aead, err := recipientWrapCipher(shared, slot.EphemeralKey, slot.RecipientKey)
+	if err != nil {
+		return nil, err
+	}
+
+	slot.WrappedKey = aead.Seal(nil, make([]byte, aead.NonceSize()), r.contentKey, nil)
+	return slot, nil
+}
+
+func (r *RecipientsEncryptionHandler) unwrapKey(slot *recipientSlot) ([]byte, error) {
+	ephemeral, err := ecdh.X25519().NewPublicKey(slot.EphemeralKey)
+	if err != nil {
+		return nil, fmt.Errorf("error reading ephemeral key: %w", err)
+	}
+
+	shared, err := r.PrivateKey.ECDH(ephemeral)
+	if err != nil {
+		return nil, fmt.Errorf("error during key exchange: %w", err)
+	}
+
+	aead, err := recipientWrapCipher(shared, slot.EphemeralKey, slot.RecipientKey)
+	if err != nil {
+		return nil, err
+	}
+
+	contentKey, err := aead.Open(nil, make([]byte, aead.NonceSize()), slot.WrappedKey, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: could not unwrap content key", ErrWrongKey)
+	}
+
+	return contentKey, nil
+}
+
+func (r *RecipientsEncryptionHandler) content() (*AESEncryptionHandler, error) {
+	if r.contentKey == nil {
+		return nil, errors.New("content key has not been loaded or generated")
+	}
+	return &AESEncryptionHandler{r.contentKey}, nil
+}
+
+func (r *RecipientsEncryptionHandler) Decrypt(output io.Writer, associatedData []byte) (io.WriteCloser, error) {
+	handler, err := r.content()
+	if err != nil {
+		return nil, err
+	}
+	return handler.Decrypt(output, associatedData)
+}
+
+func (r *RecipientsEncryptionHandler) Encrypt(output io.Writer, associatedData []byte) (io.WriteCloser, error) {
+	handler, err := r.content()
+	if err != nil {
+		return nil, err
+	}
+	return handler.Encrypt(output, associatedData)
+}
+
+func (r *RecipientsEncryptionHandler) DecryptAt(input io.ReaderAt, size int64, associatedData []byte) (io.ReaderAt, int64, error) {
+	handler, err := r.content()
+	if err != nil {
+		return nil, 0, err
+	}
+	return handler.DecryptAt(input, size, associatedData)
+}
@@ -0,0 +1,260 @@
+package jpkg_impl
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// dictionary sizes of the xz presets 0 to 9
+var xzPresetDictionarySizes = [10]int{
+	256 << 10, 1 << 20, 2 << 20, 4 << 20, 4 << 20, 8 << 20, 8 << 20, 16 << 20, 32 << 20, 64 << 20,
+}
+
+// the largest dictionary an xz stream may name, the same as preset 9
+const XZ_MAX_DICTIONARY_SIZE = MAX_WINDOW_SIZE
+
+// LZMA2 in an xz container, for the best ratio at the cost of encoding speed. Preset is
+// from 1 to 9 like xz -1 to -9, and 0 uses the default of 6. DictionarySize overrides
+// the preset's dictionary size if set, up to XZ_MAX_DICTIONARY_SIZE. a dictionary larger
+// than ChunkSize won't help
+type XZCompressionHandler struct {
+	Preset         int
+	DictionarySize int
+}
+
+// Flag implements CompressionHandler.
+func (x *XZCompressionHandler) Flag() CompressionFlag {
+	return COMPRESSION_XZ
+}
+
+func (x *XZCompressionHandler) Decompress(compressed []byte) ([]byte, error) {
+	return decompressBytes(x, compressed)
+}
+
+func (x *XZCompressionHandler) Compress(uncompressed []byte) ([]byte, error) {
+	return compressBytes(x, uncompressed)
+}
+
+func (x *XZCompressionHandler) DecompressReader(compressed io.Reader) (io.ReadCloser, error) {
+	source, ok := compressed.(xzSource)
+	if !ok {
+		b, err := io.ReadAll(compressed)
+		if err != nil {
+			return nil, fmt.Errorf("error reading xz stream: %w", err)
+		}
+		source = bytes.NewReader(b)
+	}
+
+	if err := checkXZDictionaries(source); err != nil {
+		return nil, fmt.Errorf("error creating xz decompressor: %w", err)
+	}
+
+	// the smallest capacity, so the dictionary size from the stream is used
+	config := xz.ReaderConfig{DictCap: lzma.MinDictCap, SingleStream: true}
+	reader, err := config.NewReader(io.NewSectionReader(source, 0, source.Size()))
+	if err != nil {
+		return nil, fmt.Errorf("error creating xz decompressor: %w", err)
+	}
+	return io.NopCloser(reader), nil
+}
+
+func (x *XZCompressionHandler) CompressWriter(output io.Writer) (io.WriteCloser, error) {
+	preset := x.Preset
+	if preset == 0 {
+		preset = 6
+	}
+	if preset < 0 || preset > 9 {
+		return nil, fmt.Errorf("invalid xz preset: %v", x.Preset)
+	}
+
+	config := xz.WriterConfig{
+		DictCap: xzPresetDictionarySizes[preset],
+		Matcher: lzma.BinaryTree,
+	}
+	if preset <= 3 {
+		config.Matcher = lzma.HashTable4
+	}
+	if x.DictionarySize != 0 {
+		config.DictCap = x.DictionarySize
+	}
+	if config.DictCap > XZ_MAX_DICTIONARY_SIZE {
+		return nil, fmt.Errorf("xz dictionary size %v is larger than %v", config.DictCap, XZ_MAX_DICTIONARY_SIZE)
+	}
+
+	if err := config.Verify(); err != nil {
+		return nil, fmt.Errorf("error creating xz compressor: %w", err)
+	}
+
+	return &xzChunkWriter{output: output, config: config}, nil
+}
+
+// decoders allocate the whole dictionary named in the stream, so the input is buffered
+// until Close and the dictionary shrunk to fit it
+type xzChunkWriter struct {
+	output io.Writer
+	config xz.WriterConfig
+	buffer bytes.Buffer
+}
+
+func (x *xzChunkWriter) Write(p []byte) (int, error) {
+	return x.buffer.Write(p)
+}
+
+func (x *xzChunkWriter) Close() error {
+	config := x.config
+	config.DictCap = min(config.DictCap, max(x.buffer.Len(), lzma.MinDictCap))
+
+	writer, err := config.NewWriter(x.output)
+	if err != nil {
+		return fmt.Errorf("error creating xz compressor: %w", err)
+	}
+
+	if _, err := x.buffer.WriteTo(writer); err != nil {
+		return fmt.Errorf("error during xz compression: %w", err)
+	}
+
+	return writer.Close()
+}
+
+type xzSource interface {
+	io.ReaderAt
+	Size() int64
+}
+
+var errXZMalformed = errors.New("malformed xz stream")
+
+// walks the block and LZMA2 chunk headers without decompressing anything, rejecting
+// any block whose dictionary is larger than XZ_MAX_DICTIONARY_SIZE. the decoder reads
+// the same headers, and checks everything else as it goes
+func checkXZDictionaries(source xzSource) error {
+	streamHeader := make([]byte, 12)
+	if _, err := source.ReadAt(streamHeader, 0); err != nil {
+		return fmt.Errorf("error reading xz stream header: %w", err)
+	}
+	if !bytes.Equal(streamHeader[:6], []byte{0xFD, '7', 'z', 'X', 'Z', 0x00}) {
+		return fmt.Errorf("%w: bad magic", errXZMalformed)
+	}
+
+	checkSize := int64(0)
+	if checkType := streamHeader[7] & 0x0F; checkType != 0 {
+		checkSize = 4 << ((checkType - 1) / 3)
+	}
+
+	pos := int64(12)
+	for {
+		headerSize := make([]byte, 1)
+		if _, err := source.ReadAt(headerSize, pos); err != nil {
+			return fmt.Errorf("error reading xz block header: %w", err)
+		}
+		// the index follows the last block
+		if headerSize[0] == 0 {
+			return nil
+		}
+
+		header := make([]byte, (int64(headerSize[0])+1)*4)
+		if _, err := source.ReadAt(header, pos); err != nil {
+			return fmt.Errorf("error reading xz block header: %w", err)
+		}
+
+		dictionarySize, err := xzBlockDictionarySize(header)
+		if err != nil {
+			return err
+		}
+		if dictionarySize > XZ_MAX_DICTIONARY_SIZE {
+			return fmt.Errorf("%w: dictionary size %v is larger than %v", errXZMalformed, dictionarySize, XZ_MAX_DICTIONARY_SIZE)
+		}
+
+		dataStart := pos + int64(len(header))
+		pos, err = skipLZMA2Chunks(source, dataStart)
+		if err != nil {
+			return err
+		}
+
+		// blocks are padded to a multiple of 4 bytes, then followed by the check
+		pos += (4-(pos-dataStart)%4)%4 + checkSize
+	}
+}
+
+// finds the LZMA2 filter in a block header and returns its dictionary size
+func xzBlockDictionarySize(header []byte) (int64, error) {
+	flags := header[1]
+	fields := header[2 : len(header)-4]
+
+	readVarint := func() (uint64, error) {
+		v, n := binary.Uvarint(fields)
+		if n <= 0 {
+			return 0, fmt.Errorf("%w: bad block header", errXZMalformed)
+		}
+		fields = fields[n:]
+		return v, nil
+	}
+
+	// compressed and uncompressed sizes
+	for _, present := range []bool{flags&0x40 != 0, flags&0x80 != 0} {
+		if present {
+			if _, err := readVarint(); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	for range flags&0x03 + 1 {
+		id, err := readVarint()
+		if err != nil {
+			return 0, err
+		}
+		propertiesSize, err := readVarint()
+		if err != nil {
+			return 0, err
+		}
+		if propertiesSize > uint64(len(fields)) {
+			return 0, fmt.Errorf("%w: bad block header", errXZMalformed)
+		}
+		properties := fields[:propertiesSize]
+		fields = fields[propertiesSize:]
+
+		if id == 0x21 && len(properties) == 1 {
+			return lzma.DecodeDictCap(properties[0])
+		}
+	}
+
+	return 0, fmt.Errorf("%w: block has no LZMA2 filter", errXZMalformed)
+}
+
+// skips over the LZMA2 chunks starting at pos, returning the position after the end marker
+func skipLZMA2Chunks(source xzSource, pos int64) (int64, error) {
+	header := make([]byte, 6)
+	for {
+		if _, err := source.ReadAt(header[:1], pos); err != nil {
+			return 0, fmt.Errorf("error reading LZMA2 chunk header: %w", err)
+		}
+
+		control := header[0]
+		switch {
+		case control == 0x00:
+			return pos + 1, nil
+		case control == 0x01 || control == 0x02:
+			if _, err := source.ReadAt(header[:3], pos); err != nil {
+				return 0, fmt.Errorf("error reading LZMA2 chunk header: %w", err)
+			}
+			pos += 3 + int64(binary.BigEndian.Uint16(header[1:3])) + 1
+		case control >= 0x80:
+			if _, err := source.ReadAt(header[:5], pos); err != nil {
+				return 0, fmt.Errorf("error reading LZMA2 chunk header: %w", err)
+			}
+			headerLength := int64(5)
+			if control >= 0xC0 {
+				headerLength = 6
+			}
+			pos += headerLength + int64(binary.BigEndian.Uint16(header[3:5])) + 1
+		default:
+			return 0, fmt.Errorf("%w: bad LZMA2 chunk control byte %#x", errXZMalformed, control)
+		}
+	}
+}
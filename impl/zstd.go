@@ -0,0 +1,96 @@
+package jpkg_impl
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Zstandard frames. levels are the same as the zstd cli, from 1 (fastest) to 22 (best),
+// and 0 uses the default
+type ZstdCompressionHandler struct {
+	Level      int
+	dictionary []byte
+}
+
+// Flag implements CompressionHandler.
+func (z *ZstdCompressionHandler) Flag() CompressionFlag {
+	return COMPRESSION_ZSTD
+}
+
+func (z *ZstdCompressionHandler) Decompress(compressed []byte) ([]byte, error) {
+	return decompressBytes(z, compressed)
+}
+
+func (z *ZstdCompressionHandler) Compress(uncompressed []byte) ([]byte, error) {
+	return compressBytes(z, uncompressed)
+}
+
+func (z *ZstdCompressionHandler) DecompressReader(compressed io.Reader) (io.ReadCloser, error) {
+	options := []zstd.DOption{
+		zstd.WithDecoderConcurrency(1),
+		zstd.WithDecoderMaxWindow(MAX_WINDOW_SIZE),
+		zstd.WithDecoderMaxMemory(MAX_WINDOW_SIZE),
+	}
+	if z.dictionary != nil {
+		options = append(options, zstd.WithDecoderDicts(z.dictionary))
+	}
+
+	decoder, err := zstd.NewReader(compressed, options...)
+	if err != nil {
+		return nil, fmt.Errorf("error creating zstd decompressor: %w", err)
+	}
+	return decoder.IOReadCloser(), nil
+}
+
+func (z *ZstdCompressionHandler) level() zstd.EncoderLevel {
+	if z.Level == 0 {
+		return zstd.SpeedDefault
+	}
+	return zstd.EncoderLevelFromZstd(z.Level)
+}
+
+func (z *ZstdCompressionHandler) CompressWriter(output io.Writer) (io.WriteCloser, error) {
+	// chunks are compressed one at a time, so extra goroutines would only add overhead
+	options := []zstd.EOption{zstd.WithEncoderLevel(z.level()), zstd.WithEncoderConcurrency(1)}
+	if z.dictionary != nil {
+		options = append(options, zstd.WithEncoderDict(z.dictionary))
+	}
+
+	encoder, err := zstd.NewWriter(output, options...)
+	if err != nil {
+		return nil, fmt.Errorf("error creating zstd compressor: %w", err)
+	}
+	return encoder, nil
+}
+
+// TrainDictionary implements DictionaryCompressionHandler. the dictionary is in the zstd
+// format, with entropy tables built from the samples on top of about size bytes of content
+func (z *ZstdCompressionHandler) TrainDictionary(samples [][]byte, size int) ([]byte, error) {
+	content := trainDictionaryContent(samples, size)
+	if len(content) < 8 {
+		return nil, errors.New("samples have too little in common to train a dictionary")
+	}
+
+	dictionary, err := zstd.BuildDict(zstd.BuildDictOptions{
+		// in the range zstd leaves for dictionaries that aren't registered
+		ID:       32768 + crc32.ChecksumIEEE(content)%(1<<31-32768),
+		Contents: samples,
+		History:  content,
+		Offsets:  [3]int{1, 4, 8},
+		Level:    z.level(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error building zstd dictionary: %w", err)
+	}
+
+	return dictionary, nil
+}
+
+// WithDictionary implements DictionaryCompressionHandler.
+func (z *ZstdCompressionHandler) WithDictionary(dictionary []byte) (CompressionHandler, error) {
+	return &ZstdCompressionHandler{Level: z.Level, dictionary: dictionary}, nil
+}
@@ -0,0 +1,151 @@
+package jpkg
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"unicode/utf8"
+
+	jpkg_bin "github.com/j4d3blooded/JPkg/bin"
+)
+
+// ReaderOptions limits with the defaults filled in
+type jpkgLimits struct {
+	maxStringLength     uint64
+	maxMetadataSize     uint64
+	maxFileCount        uint64
+	maxUncompressedSize uint64
+	maxCompressionRatio uint64
+}
+
+func (o ReaderOptions) limits() jpkgLimits {
+	limits := jpkgLimits{
+		maxStringLength:     o.MaxStringLength,
+		maxMetadataSize:     o.MaxMetadataSize,
+		maxFileCount:        o.MaxFileCount,
+		maxUncompressedSize: o.MaxUncompressedSize,
+		maxCompressionRatio: o.MaxCompressionRatio,
+	}
+
+	if limits.maxStringLength == 0 {
+		limits.maxStringLength = DEFAULT_MAX_STRING_LENGTH
+	}
+	if limits.maxMetadataSize == 0 {
+		limits.maxMetadataSize = DEFAULT_MAX_METADATA_SIZE
+	}
+	if limits.maxFileCount == 0 {
+		limits.maxFileCount = DEFAULT_MAX_FILE_COUNT
+	}
+	if limits.maxUncompressedSize == 0 {
+		limits.maxUncompressedSize = DEFAULT_MAX_UNCOMPRESSED_SIZE
+	}
+	if limits.maxCompressionRatio == 0 {
+		limits.maxCompressionRatio = DEFAULT_MAX_COMPRESSION_RATIO
+	}
+
+	return limits
+}
+
+// strings are checked against their own limit once read, this only bounds what's allocated
+func readLimited[T any](r io.Reader, header *JPkgHeader, limits jpkgLimits) (*T, error) {
+	return jpkg_bin.BinaryReadEncoded[T](r, max(limits.maxStringLength, limits.maxMetadataSize), header.strings())
+}
+
+// the size of T with every string and byte slice empty, the least any T can take up
+func minimumSize[T any]() uint64 {
+	b := bytes.Buffer{}
+	jpkg_bin.BinaryWrite(&b, *new(T))
+	return uint64(b.Len())
+}
+
+func (l jpkgLimits) checkManifest(manifest *JPkgManifest) error {
+	if length(manifest.PackageName) > l.maxStringLength {
+		return fmt.Errorf("%w: package name is %v characters", ErrLimitExceeded, length(manifest.PackageName))
+	}
+
+	if length(manifest.PackageMetadataJSON) > l.maxMetadataSize {
+		return fmt.Errorf("%w: package metadata is %v characters", ErrLimitExceeded, length(manifest.PackageMetadataJSON))
+	}
+
+	if manifest.FileCount > l.maxFileCount {
+		return fmt.Errorf("%w: package has %v files", ErrLimitExceeded, manifest.FileCount)
+	}
+
+	return nil
+}
+
+// strings are limited by their length in characters, however they're stored
+func length(s string) uint64 {
+	return uint64(utf8.RuneCountInString(s))
+}
+
+// every file takes up at least minimum bytes, so there can't be more than fit in what's left
+func checkFileCount(fileCount, left, minimum uint64) error {
+	if fileCount > left/minimum {
+		return fmt.Errorf("%v files can't fit in %v bytes", fileCount, left)
+	}
+	return nil
+}
+
+func (l jpkgLimits) checkRecord(record *JPkgFileRecordWithoutData) error {
+	if length(record.FilePath) > l.maxStringLength || length(record.FileIdentifier) > l.maxStringLength {
+		return fmt.Errorf("%w: file path or identifier is too long", ErrLimitExceeded)
+	}
+
+	if length(record.FileMetadataJSON) > l.maxMetadataSize {
+		return fmt.Errorf("%w: file metadata is %v characters", ErrLimitExceeded, length(record.FileMetadataJSON))
+	}
+
+	return nil
+}
+
+// checks every record lies within the package, and against the size and ratio limits.
+// solid blocks are checked as a whole, as they're decoded in one go
+func (l jpkgLimits) checkFiles(files []JPkgFileRecordWithOffset, solidBlocks map[int64]*jpkgSolidBlock, size int64) error {
+	total := uint64(0)
+
+	for _, file := range files {
+		if file.Offset > uint64(size) || file.CompressedDataSize > uint64(size)-file.Offset {
+			return newCorrupt(int64(file.Offset), int64(file.index), "file %v lies outside the package", file.FilePath)
+		}
+
+		// sizes are used as int64 once the files are opened
+		if file.UncompressedDataSize > math.MaxInt64 {
+			return newCorrupt(int64(file.Offset), int64(file.index), "file %v is %v bytes uncompressed", file.FilePath, file.UncompressedDataSize)
+		}
+
+		if file.UncompressedDataSize > math.MaxUint64-total {
+			return fmt.Errorf("%w: package is larger than %v bytes uncompressed", ErrLimitExceeded, uint64(math.MaxUint64))
+		}
+		total += file.UncompressedDataSize
+
+		if file.block == nil && !l.ratioAllowed(file.UncompressedDataSize, file.CompressedDataSize) {
+			return fmt.Errorf("%w: file %v is compressed more than %v times", ErrLimitExceeded, file.FilePath, l.maxCompressionRatio)
+		}
+	}
+
+	if total > l.maxUncompressedSize {
+		return fmt.Errorf("%w: package is %v bytes uncompressed", ErrLimitExceeded, total)
+	}
+
+	for offset, block := range solidBlocks {
+		if block.size > math.MaxInt64 {
+			return newCorrupt(offset, -1, "solid block is %v bytes uncompressed", block.size)
+		}
+
+		if !l.ratioAllowed(block.size, block.storedSize) {
+			return fmt.Errorf("%w: solid block at %v is compressed more than %v times", ErrLimitExceeded, offset, l.maxCompressionRatio)
+		}
+	}
+
+	return nil
+}
+
+// uncompressedSize is no more than maxCompressionRatio times compressedSize
+func (l jpkgLimits) ratioAllowed(uncompressedSize, compressedSize uint64) bool {
+	if uncompressedSize == 0 {
+		return true
+	}
+	return compressedSize != 0 && (uncompressedSize-1)/compressedSize < l.maxCompressionRatio
+}
@@ -0,0 +1,155 @@
+package jpkg
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+
+	jpkg_impl "github.com/j4d3blooded/JPkg/impl"
+)
+
+// chunks are compressed and encrypted by a pool of workers, while a single writer goroutine
+// writes everything in the order it was queued. the queue is bounded, so only a few chunks
+// per worker are held in memory at once
+type jpkgPipeline struct {
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	jobs   chan *jpkgChunkJob
+	steps  chan jpkgWriteStep
+	done   sync.WaitGroup
+}
+
+type jpkgChunkJob struct {
+	record         string
+	index          int
+	data           []byte
+	associatedData []byte
+	compression    jpkg_impl.CompressionHandler
+	encryption     jpkg_impl.EncryptionHandler
+	output         bytes.Buffer
+	err            error
+	done           chan struct{}
+}
+
+// write is called with the output of job, or nil for steps without a job
+type jpkgWriteStep struct {
+	job   *jpkgChunkJob
+	write func(output []byte) error
+}
+
+func (j *JPkgEncoder) workers() int {
+	if j.Workers <= 0 {
+		return runtime.GOMAXPROCS(0)
+	}
+	return j.Workers
+}
+
+func (j *JPkgEncoder) startPipeline(ctx context.Context) *jpkgPipeline {
+	workers := j.workers()
+
+	p := &jpkgPipeline{
+		jobs:  make(chan *jpkgChunkJob, workers),
+		steps: make(chan jpkgWriteStep, 2*workers),
+	}
+	p.ctx, p.cancel = context.WithCancelCause(ctx)
+
+	p.done.Add(workers + 1)
+	for range workers {
+		go p.work()
+	}
+	go p.write()
+
+	return p
+}
+
+func (p *jpkgPipeline) work() {
+	defer p.done.Done()
+
+	for job := range p.jobs {
+		if p.ctx.Err() == nil { // jobs are still drained once cancelled, but not encoded
+			job.encode()
+		}
+		close(job.done)
+	}
+}
+
+func (c *jpkgChunkJob) encode() {
+	compressed := bytes.Buffer{}
+	if err := compressChunk(c.compression, &compressed, c.data); err != nil {
+		c.err = fmt.Errorf("error writing %v: error compressing chunk %v: %w", c.record, c.index, err)
+		return
+	}
+
+	if err := encryptChunk(c.encryption, &c.output, compressed.Bytes(), c.associatedData); err != nil {
+		c.err = fmt.Errorf("error writing %v: error encrypting chunk %v: %w", c.record, c.index, err)
+	}
+}
+
+func (p *jpkgPipeline) write() {
+	defer p.done.Done()
+
+	for step := range p.steps {
+		if p.ctx.Err() != nil {
+			continue
+		}
+
+		var output []byte
+		if step.job != nil {
+			<-step.job.done
+			if step.job.err != nil {
+				p.cancel(step.job.err)
+				continue
+			}
+			output = step.job.output.Bytes()
+		}
+
+		if err := step.write(output); err != nil {
+			p.cancel(err)
+		}
+	}
+}
+
+// runs write on the writer goroutine, after everything queued before it is written
+func (p *jpkgPipeline) queueWrite(write func() error) error {
+	return p.send(jpkgWriteStep{write: func([]byte) error { return write() }})
+}
+
+// encodes the chunk on a worker, then runs write with its output on the writer goroutine
+func (p *jpkgPipeline) queueChunk(job *jpkgChunkJob, write func(output []byte) error) error {
+	job.done = make(chan struct{})
+
+	select {
+	case p.jobs <- job:
+	case <-p.ctx.Done():
+		return context.Cause(p.ctx)
+	}
+
+	return p.send(jpkgWriteStep{job, write})
+}
+
+func (p *jpkgPipeline) send(step jpkgWriteStep) error {
+	select {
+	case p.steps <- step:
+		return nil
+	case <-p.ctx.Done():
+		return context.Cause(p.ctx)
+	}
+}
+
+// waits for everything queued to be written. returns the first error from queueing,
+// encoding or writing, or the cancellation cause of the context
+func (p *jpkgPipeline) finish(err error) error {
+	if err != nil {
+		p.cancel(err)
+	}
+
+	close(p.jobs)
+	close(p.steps)
+	p.done.Wait()
+
+	err = context.Cause(p.ctx)
+	p.cancel(nil)
+	return err
+}
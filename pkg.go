@@ -3,13 +3,15 @@ package jpkg
 import (
 	"bytes"
 	"encoding/json"
-	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"io/fs"
 	"regexp"
+	"sync/atomic"
 	"time"
 
+	jpkg_bin "github.com/j4d3blooded/JPkg/bin"
 	jpkg_impl "github.com/j4d3blooded/JPkg/impl"
 )
 
@@ -22,6 +24,11 @@ type JPkgHeader struct {
 	SignatureFlag   jpkg_impl.CryptoFlag
 }
 
+// only present when the encryption handler is a jpkg_impl.ParameterizedEncryptionHandler
+type JPkgEncryptionParameters struct {
+	Parameters []byte
+}
+
 type JPkgManifest struct {
 	PackagedAt          int64
 	FileCount           uint64
@@ -38,24 +45,66 @@ type JPkgFileRecordWithoutData struct {
 	UncompressedDataSize uint64
 }
 
-type _JPkgFileRecord struct {
+type JPkgFileRecordWithOffset struct {
 	JPkgFileRecordWithoutData
-	CompressedData []byte
+	Offset uint64
+	Digest []byte
+	JPkgRecordFlags
+	index uint64
+	// nil unless the record is in a solid block
+	block *JPkgSolidBlockReference
 }
 
-type JPkgFileRecordWithOffset struct {
+// shared by every file using the package compression
+type JPkgDictionary struct {
+	Dictionary []byte
+}
+
+// follows every directory entry when the trailer has TRAILER_FLAG_SOLID_BLOCKS. for files
+// in a solid block, the entry's offset and compressed size are the block's, and this is
+// where the file starts in the uncompressed block
+type JPkgSolidBlockReference struct {
+	BlockOffset uint64
+}
+
+// how a single record's data is stored, which can differ from the package defaults in the header
+type JPkgRecordFlags struct {
+	CompressionFlag jpkg_impl.CompressionFlag
+	EncryptionFlag  jpkg_impl.EncryptionFlag
+}
+
+// directory entries written without TRAILER_FLAG_RECORD_FLAGS, every record uses the header flags
+type jpkgLegacyDirectoryEntry struct {
 	JPkgFileRecordWithoutData
 	Offset uint64
+	Digest []byte
+}
+
+type JPkgTrailer struct {
+	MagicNumber     uint32
+	DirectoryOffset uint64
+	DirectorySize   uint64
+	Flags           uint64
 }
 
 type JPkg struct {
-	reader             io.ReadSeeker
+	reader             io.ReaderAt
+	size               int64
 	pathsToFiles       map[string]jpkgFileOpenerInfo
 	pathsToDirectories map[string]jpkgDirOpenerInfo
 	cHandler           jpkg_impl.CompressionHandler
 	eHandler           jpkg_impl.EncryptionHandler
+	hHandler           jpkg_impl.HasherHandler
+	sHandler           jpkg_impl.CryptoHandler
+	chunkedRecords     bool
+	solidBlocks        map[int64]*jpkgSolidBlock
+	blockCache         *jpkgBlockCache
+	context            []byte
+	strings            jpkg_bin.StringEncoding
 	signatureValid     bool
-	integrityValid     bool
+	// files without encryption in an encrypted package can be read
+	unencryptedAllowed bool
+	integrityValid     atomic.Bool
 	packagedAt         time.Time
 	name               string
 	metadata           []byte
@@ -66,38 +115,67 @@ func (j *JPkg) Open(name string) (fs.File, error) {
 	name = normalizeFilePath(name)
 
 	if fileInfo, isFile := j.pathsToFiles[name]; isFile {
-		_, err := j.reader.Seek(fileInfo.offset, io.SeekStart)
-		if err != nil {
-			return nil, fmt.Errorf("error seeking with package reader: %w", err)
+		if fileInfo.solid {
+			return j.openSolidFile(fileInfo)
 		}
 
-		decrypted := bytes.Buffer{}
-		decryptor, err := j.eHandler.Decrypt(&decrypted)
+		data := io.NewSectionReader(j.reader, fileInfo.offset, int64(fileInfo.compressedSize))
+
+		chunks, err := j.readChunkTable(data, fileInfo)
 		if err != nil {
-			return nil, fmt.Errorf("error creating aes decryptor: %w", err)
+			return nil, fmt.Errorf("error reading chunk table: %w", err)
 		}
-		if _, err := io.CopyN(decryptor, j.reader, int64(fileInfo.compressedSize)); err != nil {
-			return nil, fmt.Errorf("error decrypting file data: %w", err)
+
+		var verifier hash.Hash
+		if len(fileInfo.digest) != 0 {
+			verifier = j.hHandler.New()
 		}
 
-		decompressed, err := j.cHandler.Decompress(decrypted.Bytes())
+		cHandler, eHandler, err := j.recordHandlers(JPkgRecordFlags{fileInfo.compressionFlag, fileInfo.encryptionFlag})
 		if err != nil {
-			return nil, fmt.Errorf("error decompressing file data: %w", err)
+			return nil, fmt.Errorf("error opening %v: %w", fileInfo.path, err)
 		}
 
-		buffer := bytes.NewReader(decompressed)
+		associatedData, err := recordAssociatedData(jpkgRecordIdentity{
+			Context:    j.context,
+			Index:      fileInfo.index,
+			UUID:       fileInfo.uuid,
+			Path:       fileInfo.path,
+			Identifier: fileInfo.identifier,
+			Metadata:   string(fileInfo.metadata),
+		}, j.strings)
+		if err != nil {
+			return nil, err
+		}
 
-		return &JPkgFile{
+		file := &JPkgFile{
 			pkg:        j,
 			name:       fileInfo.name,
 			size:       int64(fileInfo.uncompressedSize),
-			closed:     false,
-			buffer:     *buffer,
 			path:       fileInfo.path,
 			identifier: fileInfo.identifier,
 			uuid:       fileInfo.uuid,
 			metadata:   fileInfo.metadata,
-		}, nil
+			data:       data,
+			chunks:     chunks,
+			chunkIdx:   -1,
+			digest:     fileInfo.digest,
+			verifier:   verifier,
+			cHandler:   cHandler,
+			eHandler:   eHandler,
+
+			recordIndex:    int64(fileInfo.index),
+			associatedData: associatedData,
+		}
+
+		// tampered or transplanted records fail here rather than on their first read
+		if eHandler.Flag() != jpkg_impl.ENCRYPTION_NONE && chunks.count() > 0 {
+			if _, err := file.getChunk(0); err != nil {
+				return nil, fmt.Errorf("error opening %v: %w", fileInfo.path, err)
+			}
+		}
+
+		return file, nil
 	}
 
 	if dirInfo, isDir := j.pathsToDirectories[name]; isDir {
@@ -109,7 +187,7 @@ func (j *JPkg) Open(name string) (fs.File, error) {
 		}, nil
 	}
 
-	return nil, fs.ErrNotExist
+	return nil, &fs.PathError{Op: "open", Path: name, Err: ErrNotFound}
 }
 
 func (j *JPkg) ReadDir(name string) ([]fs.DirEntry, error) {
@@ -119,7 +197,7 @@ func (j *JPkg) ReadDir(name string) ([]fs.DirEntry, error) {
 	dirInfo, isDir := j.pathsToDirectories[name]
 
 	if !isDir {
-		return nil, fs.ErrNotExist
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: ErrNotFound}
 	}
 
 	entries := make([]fs.DirEntry, len(dirInfo.ChildPaths))
@@ -135,14 +213,15 @@ func (j *JPkg) ReadDir(name string) ([]fs.DirEntry, error) {
 			}
 		} else if fileInfo, isFile := j.pathsToFiles[child]; isFile {
 			entries[i] = &JPkgDirInfo{
-				pkg:   j,
-				path:  child,
-				name:  fileInfo.name,
-				size:  int64(fileInfo.uncompressedSize),
-				isDir: false,
+				pkg:    j,
+				path:   child,
+				name:   fileInfo.name,
+				size:   int64(fileInfo.uncompressedSize),
+				isDir:  false,
+				digest: fileInfo.digest,
 			}
 		} else {
-			panic(fmt.Errorf("child is not real? %v", child))
+			return nil, fmt.Errorf("%w: child %v of %v", ErrNotFound, child, name)
 		}
 	}
 
@@ -161,6 +240,72 @@ func (j *JPkg) GetFlagsAndInfo() (jpkg_impl.CompressionFlag, jpkg_impl.Encryptio
 	return j.cHandler.Flag(), j.eHandler.Flag()
 }
 
+// records using the package compression share its handler, which holds the dictionary
+func (j *JPkg) recordHandlers(flags JPkgRecordFlags) (jpkg_impl.CompressionHandler, jpkg_impl.EncryptionHandler, error) {
+	cHandler := j.cHandler
+	if flags.CompressionFlag != cHandler.Flag() {
+		var err error
+		cHandler, err = jpkg_impl.GetCompressionHandler(flags.CompressionFlag)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	eHandler := j.eHandler
+	if flags.EncryptionFlag == jpkg_impl.ENCRYPTION_NONE && eHandler.Flag() != jpkg_impl.ENCRYPTION_NONE {
+		// the directory could have been edited to say so, swapping the file for any data
+		if !j.unencryptedAllowed {
+			return nil, nil, fmt.Errorf("%w: file is stored without encryption, and the directory isn't authenticated", ErrAuthenticationFailed)
+		}
+		eHandler = &jpkg_impl.NullEncryptionHandler{}
+	}
+
+	return cHandler, eHandler, nil
+}
+
+// true only if a public key was given when reading, and the package signature matched it
+func (j *JPkg) SignatureValid() bool {
+	return j.signatureValid
+}
+
+// re-hashes the package and compares it against the hash in the footer,
+// returns ErrIntegrityMismatch if they differ
+func (j *JPkg) VerifyIntegrity() error {
+	j.integrityValid.Store(false)
+
+	if j.hHandler.Flag() == jpkg_impl.HASHER_NONE {
+		return ErrNotHashed
+	}
+
+	hashSize := int64(j.hHandler.Size())
+	hashOffset := j.size - int64(j.sHandler.SignatureSize()) - hashSize
+	if hashOffset < 0 {
+		return fmt.Errorf("%w: package is too small to contain a hash", ErrIntegrityMismatch)
+	}
+
+	digest := j.hHandler.New()
+	if _, err := io.Copy(digest, io.NewSectionReader(j.reader, 0, hashOffset)); err != nil {
+		return fmt.Errorf("error hashing package: %w", err)
+	}
+
+	expected := make([]byte, hashSize)
+	if _, err := j.reader.ReadAt(expected, hashOffset); err != nil {
+		return fmt.Errorf("error reading package hash: %w", err)
+	}
+
+	if !bytes.Equal(digest.Sum(nil), expected) {
+		return ErrIntegrityMismatch
+	}
+
+	j.integrityValid.Store(true)
+	return nil
+}
+
+// true only after a successful call to VerifyIntegrity
+func (j *JPkg) IntegrityValid() bool {
+	return j.integrityValid.Load()
+}
+
 func (j *JPkg) GetFileCount() int {
 	return len(j.pathsToFiles)
 }
@@ -184,7 +329,7 @@ func (j *JPkg) GetByUUID(uuid UUID) (*JPkgFile, error) {
 			return f.(*JPkgFile), nil
 		}
 	}
-	return nil, errors.New("uuid not found")
+	return nil, fmt.Errorf("%w: uuid %v", ErrNotFound, uuid)
 }
 
 func (j *JPkg) GetByIdentifier(expr *regexp.Regexp) ([]*JPkgFile, error) {
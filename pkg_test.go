@@ -0,0 +1,1238 @@
+package jpkg
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	jpkg_bin "github.com/j4d3blooded/JPkg/bin"
+	jpkg_impl "github.com/j4d3blooded/JPkg/impl"
+)
+
+type testFile struct {
+	path string
+	data []byte
+}
+
+var testFiles = []testFile{
+	{"/readme.txt", []byte("hello from jpkg")},
+	{"/assets/config.json", []byte(`{"name":"test","values":[1,2,3]}`)},
+	{"/assets/textures/empty.bin", []byte{}},
+	{"/assets/textures/repeat.bin", bytes.Repeat([]byte("abcd"), 4096)},
+}
+
+// encodes the test files to a temporary file, so record sizes get written in place
+func encodeTestPackage(t *testing.T, configure func(e *JPkgEncoder)) []byte {
+	f, err := os.Create(filepath.Join(t.TempDir(), "test.jpkg"))
+	if err != nil {
+		t.Logf("error creating package file: %v", err.Error())
+		t.FailNow()
+	}
+	defer f.Close()
+
+	encodeTestPackageTo(t, f, configure)
+
+	data, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Logf("error reading package file: %v", err.Error())
+		t.FailNow()
+	}
+
+	return data
+}
+
+func encodeTestPackageTo(t testing.TB, w io.Writer, configure func(e *JPkgEncoder)) {
+	encoder := NewJPkgEncoder(w)
+	encoder.Name = "Test Package"
+
+	if configure != nil {
+		configure(encoder)
+	}
+
+	for _, file := range testFiles {
+		err := encoder.AddFile(JPkgFileToEncode{
+			Source:     bytes.NewReader(file.data),
+			UUID:       NewUUIDV4(),
+			Identifier: file.path,
+			Path:       file.path,
+		})
+		if err != nil {
+			t.Logf("error adding file %v: %v", file.path, err.Error())
+			t.FailNow()
+		}
+	}
+
+	if err := encoder.Encode(); err != nil {
+		t.Logf("error encoding package: %v", err.Error())
+		t.FailNow()
+	}
+}
+
+func checkTestPackage(t *testing.T, pkg *JPkg) {
+	if pkg.GetFileCount() != len(testFiles) {
+		t.Logf("file count incorrect: %v", pkg.GetFileCount())
+		t.FailNow()
+	}
+
+	for _, file := range testFiles {
+		f, err := pkg.GetByPath(file.path)
+		if err != nil {
+			t.Logf("error opening %v: %v", file.path, err.Error())
+			t.FailNow()
+		}
+
+		data, err := io.ReadAll(f)
+		if err != nil {
+			t.Logf("error reading %v: %v", file.path, err.Error())
+			t.FailNow()
+		}
+
+		if !bytes.Equal(data, file.data) {
+			t.Logf("contents of %v incorrectly round tripped", file.path)
+			t.FailNow()
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	data := encodeTestPackage(t, nil)
+
+	pkg, err := ReadJPkg(bytes.NewReader(data), nil)
+	if err != nil {
+		t.Logf("error reading package: %v", err.Error())
+		t.FailNow()
+	}
+
+	if pkg.GetName() != "Test Package" {
+		t.Logf("name incorrectly round tripped: %v", pkg.GetName())
+		t.FailNow()
+	}
+
+	checkTestPackage(t, pkg)
+}
+
+func TestRoundTripCompressed(t *testing.T) {
+	data := encodeTestPackage(t, func(e *JPkgEncoder) {
+		e.Compression = &jpkg_impl.LZWCompressionHandler{}
+	})
+
+	pkg, err := ReadJPkg(bytes.NewReader(data), nil)
+	if err != nil {
+		t.Logf("error reading package: %v", err.Error())
+		t.FailNow()
+	}
+
+	checkTestPackage(t, pkg)
+}
+
+func TestRoundTripEncrypted(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, 32)
+
+	data := encodeTestPackage(t, func(e *JPkgEncoder) {
+		e.Compression = &jpkg_impl.LZWCompressionHandler{}
+		e.Encryption = &jpkg_impl.AESEncryptionHandler{Key: key}
+	})
+
+	pkg, err := ReadJPkg(bytes.NewReader(data), key)
+	if err != nil {
+		t.Logf("error reading package: %v", err.Error())
+		t.FailNow()
+	}
+
+	checkTestPackage(t, pkg)
+
+	// a wrong key of the right size is told apart from a tampered package
+	wrongKey := bytes.Repeat([]byte{0x24}, 32)
+	if _, err := ReadJPkg(bytes.NewReader(data), wrongKey); !errors.Is(err, ErrWrongKey) || errors.Is(err, ErrAuthenticationFailed) {
+		t.Logf("reading with the wrong key should fail with ErrWrongKey: %v", err)
+		t.FailNow()
+	}
+}
+
+func TestEncryptedIndex(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, 32)
+
+	data := encodeTestPackage(t, func(e *JPkgEncoder) {
+		e.Encryption = &jpkg_impl.AESEncryptionHandler{Key: key}
+		e.EncryptIndex = true
+	})
+
+	for _, plaintext := range []string{"Test Package", "readme", "config.json"} {
+		if bytes.Contains(data, []byte(plaintext)) {
+			t.Logf("package contains %v in plaintext", plaintext)
+			t.FailNow()
+		}
+	}
+
+	pkg, err := ReadJPkg(bytes.NewReader(data), key)
+	if err != nil {
+		t.Logf("error reading package: %v", err.Error())
+		t.FailNow()
+	}
+
+	if pkg.GetName() != "Test Package" {
+		t.Logf("name incorrectly round tripped: %v", pkg.GetName())
+		t.FailNow()
+	}
+
+	checkTestPackage(t, pkg)
+
+	wrongKey := bytes.Repeat([]byte{0x24}, 32)
+	if _, err := ReadJPkg(bytes.NewReader(data), wrongKey); !errors.Is(err, ErrWrongKey) {
+		t.Logf("reading with the wrong key should fail with ErrWrongKey: %v", err)
+		t.FailNow()
+	}
+}
+
+func TestPassphrase(t *testing.T) {
+	data := encodeTestPackage(t, func(e *JPkgEncoder) {
+		e.Encryption = &jpkg_impl.PassphraseEncryptionHandler{
+			Passphrase: []byte("correct horse battery staple"),
+			Iterations: 1000,
+		}
+	})
+
+	pkg, err := ReadJPkg(bytes.NewReader(data), []byte("correct horse battery staple"))
+	if err != nil {
+		t.Logf("error reading package: %v", err.Error())
+		t.FailNow()
+	}
+
+	checkTestPackage(t, pkg)
+
+	if _, err := ReadJPkg(bytes.NewReader(data), []byte("incorrect horse")); !errors.Is(err, ErrWrongKey) {
+		t.Logf("reading with the wrong passphrase should fail with ErrWrongKey: %v", err)
+		t.FailNow()
+	}
+}
+
+func TestTransplantedRecords(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, 32)
+
+	data := encodeTestPackage(t, func(e *JPkgEncoder) {
+		e.Encryption = &jpkg_impl.AESEncryptionHandler{Key: key}
+	})
+
+	pkg, err := ReadJPkg(bytes.NewReader(data), key)
+	if err != nil {
+		t.Logf("error reading package: %v", err.Error())
+		t.FailNow()
+	}
+
+	// point the readme at the config's data
+	readme := normalizeFilePath(testFiles[0].path)
+	config := normalizeFilePath(testFiles[1].path)
+	transplanted := pkg.pathsToFiles[readme]
+	transplanted.offset = pkg.pathsToFiles[config].offset
+	transplanted.compressedSize = pkg.pathsToFiles[config].compressedSize
+	transplanted.uncompressedSize = pkg.pathsToFiles[config].uncompressedSize
+	pkg.pathsToFiles[readme] = transplanted
+
+	if _, err := pkg.Open(testFiles[0].path); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Logf("opening a transplanted record should fail authentication: %v", err)
+		t.FailNow()
+	}
+
+	renamed := bytes.Clone(data)
+	renamed[bytes.Index(renamed, []byte("Test Package"))] = 'B'
+
+	pkg, err = ReadJPkg(bytes.NewReader(renamed), key)
+	if err != nil {
+		t.Logf("error reading renamed package: %v", err.Error())
+		t.FailNow()
+	}
+
+	if _, err := pkg.Open(testFiles[1].path); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Logf("opening a record of a package with a tampered manifest should fail authentication: %v", err)
+		t.FailNow()
+	}
+}
+
+func TestTruncatedRecord(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, 32)
+
+	data := encodeTestPackage(t, func(e *JPkgEncoder) {
+		e.Encryption = &jpkg_impl.AESEncryptionHandler{Key: key}
+		e.ChunkSize = 1024
+	})
+
+	pkg, err := ReadJPkg(bytes.NewReader(data), key)
+	if err != nil {
+		t.Logf("error reading package: %v", err.Error())
+		t.FailNow()
+	}
+
+	// keep the first 4 chunks of the repeating file, with a chunk table and sizes to match
+	path := normalizeFilePath(testFiles[3].path)
+	info := pkg.pathsToFiles[path]
+	chunks, err := pkg.readChunkTable(io.NewSectionReader(pkg.reader, info.offset, int64(info.compressedSize)), info)
+	if err != nil {
+		t.Logf("error reading chunk table: %v", err.Error())
+		t.FailNow()
+	}
+
+	truncated := bytes.Clone(data[info.offset : info.offset+int64(chunks.offsets[4])])
+	for i := range 4 {
+		truncated = binary.BigEndian.AppendUint64(truncated, chunks.offsets[i+1]-chunks.offsets[i])
+	}
+	truncated = binary.BigEndian.AppendUint64(binary.BigEndian.AppendUint64(truncated, 1024), 4)
+
+	pkg.reader = bytes.NewReader(append(bytes.Clone(data), truncated...))
+	info.offset = int64(len(data))
+	info.compressedSize = uint64(len(truncated))
+	info.uncompressedSize = 4 * 1024
+	pkg.pathsToFiles[path] = info
+
+	f, err := pkg.Open(testFiles[3].path)
+	if err != nil {
+		t.Logf("error opening truncated file: %v", err.Error())
+		t.FailNow()
+	}
+
+	if _, err := io.ReadAll(f); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Logf("reading a truncated record should fail authentication: %v", err)
+		t.FailNow()
+	}
+}
+
+func TestRoundTripUnseekable(t *testing.T) {
+	b := bytes.Buffer{}
+	encodeTestPackageTo(t, &b, nil)
+
+	pkg, err := ReadJPkg(bytes.NewReader(b.Bytes()), nil)
+	if err != nil {
+		t.Logf("error reading package: %v", err.Error())
+		t.FailNow()
+	}
+
+	checkTestPackage(t, pkg)
+}
+
+// writes the test files in the original layout, without chunking or a central directory
+func encodeLegacyTestPackage(t *testing.T, version uint64) []byte {
+	b := bytes.Buffer{}
+	header := JPkgHeader{MagicNumber: MAGIC_NUMBER, Version: version}
+
+	sections := []any{
+		header,
+		JPkgManifest{FileCount: uint64(len(testFiles)), PackageName: "Legacy", PackageMetadataJSON: "{}"},
+	}
+
+	for _, file := range testFiles {
+		sections = append(sections, JPkgFileRecordWithoutData{
+			FileIdentifier:       file.path,
+			FilePath:             normalizeFilePath(file.path),
+			FileMetadataJSON:     "{}",
+			CompressedDataSize:   uint64(len(file.data)),
+			UncompressedDataSize: uint64(len(file.data)),
+		})
+	}
+
+	for i, section := range sections {
+		if err := jpkg_bin.BinaryWriteEncoded(&b, section, header.strings()); err != nil {
+			t.Logf("error writing legacy section %v: %v", i, err.Error())
+			t.FailNow()
+		}
+		if header.padded() && i != 1 {
+			b.Write(padding(uint64(b.Len())))
+		}
+		if i >= 2 {
+			b.Write(testFiles[i-2].data)
+		}
+	}
+
+	return b.Bytes()
+}
+
+func TestReadWithoutDirectory(t *testing.T) {
+	for _, version := range []uint64{FORMAT_VERSION_0, FORMAT_VERSION} {
+		pkg, err := ReadJPkg(bytes.NewReader(encodeLegacyTestPackage(t, version)), nil)
+		if err != nil {
+			t.Logf("error reading version %v package without directory: %v", version, err.Error())
+			t.FailNow()
+		}
+
+		checkTestPackage(t, pkg)
+	}
+}
+
+func TestPadding(t *testing.T) {
+	configurations := []func(e *JPkgEncoder){
+		nil,
+		func(e *JPkgEncoder) {
+			e.Encryption = &jpkg_impl.PassphraseEncryptionHandler{Passphrase: []byte("correct horse"), Iterations: 1000}
+		},
+		func(e *JPkgEncoder) {
+			e.Encryption = &jpkg_impl.PassphraseEncryptionHandler{Passphrase: []byte("correct horse"), Iterations: 1000}
+			e.EncryptIndex = true
+		},
+		func(e *JPkgEncoder) {
+			e.SolidBlockSize = 1024
+		},
+	}
+
+	for i, configure := range configurations {
+		data := encodeTestPackage(t, configure)
+
+		if !bytes.Equal(data[16:32], bytes.Repeat([]byte{0xDE, 0xAD, 0xBE, 0xEF}, 4)) {
+			t.Logf("configuration %v header padding is %x", i, data[16:32])
+			t.FailNow()
+		}
+
+		pkg, err := ReadJPkg(bytes.NewReader(data), []byte("correct horse"))
+		if err != nil {
+			t.Logf("error reading configuration %v: %v", i, err.Error())
+			t.FailNow()
+		}
+
+		for path, file := range pkg.pathsToFiles {
+			if file.offset%PADDING_ALIGNMENT != 0 {
+				t.Logf("configuration %v data of %v is at %v", i, path, file.offset)
+				t.FailNow()
+			}
+		}
+
+		checkTestPackage(t, pkg)
+	}
+
+	data := encodeTestPackage(t, nil)
+	data[20] = 0
+	if _, err := ReadJPkg(bytes.NewReader(data), nil); !errors.As(err, new(*ErrCorrupt)) {
+		t.Logf("package with bad padding wasn't reported as corrupt: %v", err)
+		t.FailNow()
+	}
+}
+
+func TestPerFileHandlers(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, 32)
+	plaintext := []byte("this file is stored without encryption")
+	stored := bytes.Repeat([]byte("this file is stored without compression"), 100)
+
+	b := bytes.Buffer{}
+	encoder := NewJPkgEncoder(&b)
+	encoder.Compression = &jpkg_impl.ZstdCompressionHandler{}
+	encoder.Encryption = &jpkg_impl.AESEncryptionHandler{Key: key}
+
+	files := []JPkgFileToEncode{
+		{Path: "/default.txt", Source: bytes.NewReader(testFiles[3].data)},
+		{Path: "/plaintext.txt", Source: bytes.NewReader(plaintext), Encryption: &jpkg_impl.NullEncryptionHandler{}},
+		{Path: "/stored.bin", Source: bytes.NewReader(stored), Compression: &jpkg_impl.NullCompressionHandler{}},
+	}
+	for _, file := range files {
+		if err := encoder.AddFile(file); err != nil {
+			t.Logf("error adding file %v: %v", file.Path, err.Error())
+			t.FailNow()
+		}
+	}
+
+	if err := encoder.Encode(); err != nil {
+		t.Logf("error encoding package: %v", err.Error())
+		t.FailNow()
+	}
+
+	if !bytes.Contains(b.Bytes(), plaintext) {
+		t.Logf("file without encryption isn't stored in plaintext")
+		t.FailNow()
+	}
+
+	pkg, err := ReadJPkg(bytes.NewReader(b.Bytes()), key)
+	if err != nil {
+		t.Logf("error reading package: %v", err.Error())
+		t.FailNow()
+	}
+
+	if _, err := pkg.GetByPath("/plaintext.txt"); !errors.Is(err, ErrAuthenticationFailed) {
+		t.Logf("file without encryption was opened from an unauthenticated directory: %v", err)
+		t.FailNow()
+	}
+
+	pkg, err = ReadJPkgAt(bytes.NewReader(b.Bytes()), int64(b.Len()), ReaderOptions{EncryptionKey: key, AllowUnencryptedFiles: true})
+	if err != nil {
+		t.Logf("error reading package: %v", err.Error())
+		t.FailNow()
+	}
+
+	expected := map[string]struct {
+		data        []byte
+		compression jpkg_impl.CompressionFlag
+		encryption  jpkg_impl.EncryptionFlag
+	}{
+		"/default.txt":   {testFiles[3].data, jpkg_impl.COMPRESSION_ZSTD, jpkg_impl.ENCRYPTION_AES},
+		"/plaintext.txt": {plaintext, jpkg_impl.COMPRESSION_ZSTD, jpkg_impl.ENCRYPTION_NONE},
+		"/stored.bin":    {stored, jpkg_impl.COMPRESSION_NONE, jpkg_impl.ENCRYPTION_AES},
+	}
+
+	for path, file := range expected {
+		f, err := pkg.GetByPath(path)
+		if err != nil {
+			t.Logf("error opening %v: %v", path, err.Error())
+			t.FailNow()
+		}
+
+		compression, encryption := f.GetFlags()
+		if compression != file.compression || encryption != file.encryption {
+			t.Logf("%v has flags %v/%v, expected %v/%v", path, compression, encryption, file.compression, file.encryption)
+			t.FailNow()
+		}
+
+		data, err := io.ReadAll(f)
+		if err != nil || !bytes.Equal(data, file.data) {
+			t.Logf("contents of %v incorrectly round tripped: %v", path, err)
+			t.FailNow()
+		}
+	}
+}
+
+func TestAdaptiveCompression(t *testing.T) {
+	random := make([]byte, 100000)
+	rand.Read(random)
+	repetitive := bytes.Repeat([]byte(`{"name":"asset","values":[1,2,3]}`), 3000)
+
+	b := bytes.Buffer{}
+	encoder := NewJPkgEncoder(&b)
+	encoder.Compression = &jpkg_impl.LZWCompressionHandler{}
+	encoder.CompressionCandidates = []jpkg_impl.CompressionHandler{&jpkg_impl.ZstdCompressionHandler{}}
+	encoder.Adaptive = true
+
+	encoder.AddFile(JPkgFileToEncode{Path: "/random.bin", Source: bytes.NewReader(random)})
+	encoder.AddFile(JPkgFileToEncode{Path: "/repetitive.json", Source: bytes.NewReader(repetitive)})
+
+	if err := encoder.Encode(); err != nil {
+		t.Logf("error encoding package: %v", err.Error())
+		t.FailNow()
+	}
+
+	stats := encoder.Stats()
+	if stats.Files[0].CompressionFlag != jpkg_impl.COMPRESSION_NONE || stats.Files[1].CompressionFlag != jpkg_impl.COMPRESSION_ZSTD {
+		t.Logf("incorrect compression chosen: %v, %v", stats.Files[0].CompressionFlag, stats.Files[1].CompressionFlag)
+		t.FailNow()
+	}
+
+	if stats.Files[0].StoredSize > stats.Files[0].UncompressedSize+64 || stats.Savings() < 0.4 {
+		t.Logf("incorrect savings: %+v", stats)
+		t.FailNow()
+	}
+
+	pkg, err := ReadJPkg(bytes.NewReader(b.Bytes()), nil)
+	if err != nil {
+		t.Logf("error reading package: %v", err.Error())
+		t.FailNow()
+	}
+
+	for path, expected := range map[string][]byte{"/random.bin": random, "/repetitive.json": repetitive} {
+		f, err := pkg.GetByPath(path)
+		if err != nil {
+			t.Logf("error opening %v: %v", path, err.Error())
+			t.FailNow()
+		}
+
+		data, err := io.ReadAll(f)
+		if err != nil || !bytes.Equal(data, expected) {
+			t.Logf("contents of %v incorrectly round tripped: %v", path, err)
+			t.FailNow()
+		}
+	}
+}
+
+func TestSolidBlocks(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, 32)
+	files := map[string][]byte{}
+
+	encode := func(solidBlockSize uint64) []byte {
+		b := bytes.Buffer{}
+		encoder := NewJPkgEncoder(&b)
+		encoder.Compression = &jpkg_impl.ZstdCompressionHandler{}
+		encoder.Encryption = &jpkg_impl.AESEncryptionHandler{Key: key}
+		encoder.Hasher = &jpkg_impl.SHA256HasherHandler{}
+		encoder.SolidBlockSize = solidBlockSize
+
+		for i := range 200 {
+			path := fmt.Sprintf("/strings/%v.json", i)
+			files[path] = []byte(fmt.Sprintf(`{"id":%v,"text":"localized string number %v"}`, i, i))
+			if i == 100 { // too large for a block, so it splits the files around it
+				files[path] = testFiles[3].data
+			}
+			encoder.AddFile(JPkgFileToEncode{Path: path, Source: bytes.NewReader(files[path])})
+		}
+
+		if err := encoder.Encode(); err != nil {
+			t.Logf("error encoding package: %v", err.Error())
+			t.FailNow()
+		}
+		return b.Bytes()
+	}
+
+	separate, solid := encode(0), encode(4096)
+	if len(solid)*2 > len(separate) {
+		t.Logf("solid package isn't much smaller: %v, %v", len(solid), len(separate))
+		t.FailNow()
+	}
+
+	pkg, err := ReadJPkgAt(bytes.NewReader(solid), int64(len(solid)), ReaderOptions{EncryptionKey: key, SolidBlockCache: 1})
+	if err != nil {
+		t.Logf("error reading package: %v", err.Error())
+		t.FailNow()
+	}
+
+	if len(pkg.solidBlocks) < 2 || len(pkg.solidBlocks) > 10 {
+		t.Logf("incorrect number of solid blocks: %v", len(pkg.solidBlocks))
+		t.FailNow()
+	}
+
+	for path, expected := range files {
+		f, err := pkg.GetByPath(path)
+		if err != nil {
+			t.Logf("error opening %v: %v", path, err.Error())
+			t.FailNow()
+		}
+
+		data, err := io.ReadAll(f)
+		if err != nil || !bytes.Equal(data, expected) {
+			t.Logf("contents of %v incorrectly round tripped: %v", path, err)
+			t.FailNow()
+		}
+	}
+}
+
+func TestDictionary(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, 32)
+	files := map[string][]byte{}
+	for i := range 200 {
+		path := fmt.Sprintf("/strings/%v.json", i)
+		files[path] = []byte(fmt.Sprintf(
+			`{"id":%v,"locale":"en-GB","context":"menu","text":"localized string number %v","fallback":"en-US","plural":false}`, i, i*7919,
+		))
+	}
+
+	encode := func(dictionarySize uint64, encryptIndex bool) []byte {
+		b := bytes.Buffer{}
+		encoder := NewJPkgEncoder(&b)
+		encoder.Compression = &jpkg_impl.ZstdCompressionHandler{}
+		encoder.Encryption = &jpkg_impl.AESEncryptionHandler{Key: key}
+		encoder.EncryptIndex = encryptIndex
+		encoder.DictionarySize = dictionarySize
+
+		for path, data := range files {
+			encoder.AddFile(JPkgFileToEncode{Path: path, Source: bytes.NewReader(data)})
+		}
+
+		if err := encoder.Encode(); err != nil {
+			t.Logf("error encoding package: %v", err.Error())
+			t.FailNow()
+		}
+		return b.Bytes()
+	}
+
+	without := encode(0, false)
+
+	for _, encryptIndex := range []bool{false, true} {
+		with := encode(2048, encryptIndex)
+		if !encryptIndex && len(with) >= len(without) {
+			t.Logf("dictionary didn't make the package smaller: %v, %v", len(with), len(without))
+			t.FailNow()
+		}
+
+		pkg, err := ReadJPkgAt(bytes.NewReader(with), int64(len(with)), ReaderOptions{EncryptionKey: key})
+		if err != nil {
+			t.Logf("error reading package: %v", err.Error())
+			t.FailNow()
+		}
+
+		for path, expected := range files {
+			f, err := pkg.GetByPath(path)
+			if err != nil {
+				t.Logf("error opening %v: %v", path, err.Error())
+				t.FailNow()
+			}
+
+			data, err := io.ReadAll(f)
+			if err != nil || !bytes.Equal(data, expected) {
+				t.Logf("contents of %v incorrectly round tripped: %v", path, err)
+				t.FailNow()
+			}
+		}
+	}
+}
+
+type failingReader struct {
+	err error
+}
+
+func (f failingReader) Read([]byte) (int, error) {
+	return 0, f.err
+}
+
+func TestParallelEncode(t *testing.T) {
+	packageTime := time.Unix(1700000000, 0)
+
+	encode := func(ctx context.Context, workers int, failing io.Reader) ([]byte, error) {
+		b := bytes.Buffer{}
+		encoder := NewJPkgEncoder(&b)
+		encoder.PackageTime = packageTime
+		encoder.Compression = &jpkg_impl.DeflateCompressionHandler{}
+		encoder.Hasher = &jpkg_impl.SHA256HasherHandler{}
+		encoder.ChunkSize = 4096
+		encoder.SolidBlockSize = 512
+		encoder.Workers = workers
+
+		for i := range 100 {
+			data := bytes.Repeat([]byte(fmt.Sprintf("file %v ", i)), i*i)
+			encoder.AddFile(JPkgFileToEncode{Path: fmt.Sprintf("/%v.bin", i), Source: bytes.NewReader(data)})
+		}
+		if failing != nil {
+			encoder.AddFile(JPkgFileToEncode{Path: "/failing.bin", Source: failing})
+		}
+
+		err := encoder.EncodeContext(ctx)
+		return b.Bytes(), err
+	}
+
+	sequential, err := encode(context.Background(), 1, nil)
+	if err != nil {
+		t.Logf("error encoding package: %v", err.Error())
+		t.FailNow()
+	}
+
+	parallel, err := encode(context.Background(), 8, nil)
+	if err != nil {
+		t.Logf("error encoding package: %v", err.Error())
+		t.FailNow()
+	}
+
+	if !bytes.Equal(sequential, parallel) {
+		t.Logf("package depends on the number of workers")
+		t.FailNow()
+	}
+
+	if _, err := ReadJPkgAt(bytes.NewReader(parallel), int64(len(parallel)), ReaderOptions{}); err != nil {
+		t.Logf("error reading package: %v", err.Error())
+		t.FailNow()
+	}
+
+	errSource := errors.New("source failed")
+	if _, err := encode(context.Background(), 8, failingReader{errSource}); !errors.Is(err, errSource) {
+		t.Logf("source error wasn't returned: %v", err)
+		t.FailNow()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := encode(ctx, 8, nil); !errors.Is(err, context.Canceled) {
+		t.Logf("cancellation wasn't returned: %v", err)
+		t.FailNow()
+	}
+}
+
+// stores data as is, under a vendor flag
+type vendorCompressionHandler struct {
+	jpkg_impl.NullCompressionHandler
+}
+
+func (v *vendorCompressionHandler) Flag() jpkg_impl.CompressionFlag {
+	return jpkg_impl.VENDOR_FLAG_MIN + 1
+}
+
+func TestUnsupportedFeature(t *testing.T) {
+	data := encodeTestPackage(t, func(e *JPkgEncoder) {
+		e.Compression = &vendorCompressionHandler{}
+	})
+
+	_, err := ReadJPkgAt(bytes.NewReader(data), int64(len(data)), ReaderOptions{})
+	if !errors.Is(err, ErrUnsupportedFeature) {
+		t.Logf("unregistered compression wasn't reported: %v", err)
+		t.FailNow()
+	}
+
+	factory := func() jpkg_impl.CompressionHandler { return &vendorCompressionHandler{} }
+	if err := jpkg_impl.RegisterCompression(jpkg_impl.VENDOR_FLAG_MIN+1, factory); err != nil {
+		t.Logf("error registering compression: %v", err.Error())
+		t.FailNow()
+	}
+
+	if err := jpkg_impl.RegisterCompression(jpkg_impl.COMPRESSION_ZSTD, factory); err == nil {
+		t.Logf("standard compression was replaced")
+		t.FailNow()
+	}
+
+	if err := jpkg_impl.RegisterCompression(jpkg_impl.VENDOR_FLAG_MIN-1, factory); err == nil {
+		t.Logf("compression registered below VENDOR_FLAG_MIN")
+		t.FailNow()
+	}
+
+	pkg, err := ReadJPkgAt(bytes.NewReader(data), int64(len(data)), ReaderOptions{})
+	if err != nil {
+		t.Logf("error reading package: %v", err.Error())
+		t.FailNow()
+	}
+
+	for _, file := range testFiles {
+		f, err := pkg.GetByPath(file.path)
+		if err != nil {
+			t.Logf("error opening %v: %v", file.path, err.Error())
+			t.FailNow()
+		}
+
+		contents, err := io.ReadAll(f)
+		if err != nil || !bytes.Equal(contents, file.data) {
+			t.Logf("contents of %v incorrectly round tripped: %v", file.path, err)
+			t.FailNow()
+		}
+	}
+}
+
+func TestTypedErrors(t *testing.T) {
+	data := encodeTestPackage(t, nil)
+
+	badMagic := bytes.Clone(data)
+	badMagic[0] ^= 0xFF
+	if _, err := ReadJPkg(bytes.NewReader(badMagic), nil); !errors.Is(err, ErrBadMagic) {
+		t.Logf("bad magic number wasn't reported: %v", err)
+		t.FailNow()
+	}
+
+	badVersion := bytes.Clone(data)
+	badVersion[11] = 0xFF
+	if _, err := ReadJPkg(bytes.NewReader(badVersion), nil); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Logf("unsupported version wasn't reported: %v", err)
+		t.FailNow()
+	}
+
+	var corrupt *ErrCorrupt
+
+	forged, err := ReadJPkg(bytes.NewReader(forgedTestPackage(forgedChunkTable(), 15)), nil)
+	if err != nil {
+		t.Logf("error reading forged package: %v", err.Error())
+		t.FailNow()
+	}
+
+	if _, err := forged.Open("/forged.bin"); !errors.As(err, &corrupt) {
+		t.Logf("forged chunk table wasn't reported as corrupt: %v", err)
+		t.FailNow()
+	}
+
+	// a trailer whose directory wraps around the end of the package is ignored, and the
+	// records are scanned instead
+	if _, err := ReadJPkg(bytes.NewReader(forgedTrailer(data)), nil); err != nil {
+		t.Logf("error reading package with an overflowing trailer: %v", err.Error())
+		t.FailNow()
+	}
+
+	truncated := data[:len(data)/2]
+	if _, err := ReadJPkg(bytes.NewReader(truncated), nil); !errors.As(err, &corrupt) {
+		t.Logf("truncated package wasn't reported as corrupt: %v", err)
+		t.FailNow()
+	}
+
+	if corrupt.Offset < 0 || corrupt.Offset > int64(len(truncated)) {
+		t.Logf("corrupt offset is outside the package: %v", corrupt.Offset)
+		t.FailNow()
+	}
+
+	pkg, err := ReadJPkg(bytes.NewReader(data), nil)
+	if err != nil {
+		t.Logf("error reading package: %v", err.Error())
+		t.FailNow()
+	}
+
+	if _, err := pkg.Open("/missing.txt"); !errors.Is(err, ErrNotFound) || !errors.Is(err, fs.ErrNotExist) {
+		t.Logf("missing file wasn't reported: %v", err)
+		t.FailNow()
+	}
+
+	if _, err := pkg.GetByUUID(NewUUIDV4()); !errors.Is(err, ErrNotFound) {
+		t.Logf("missing uuid wasn't reported: %v", err)
+		t.FailNow()
+	}
+
+	encoder := NewJPkgEncoder(io.Discard)
+	for range 2 {
+		err = encoder.AddFile(JPkgFileToEncode{Source: bytes.NewReader(nil), Path: "/twice.txt"})
+	}
+	if !errors.Is(err, ErrDuplicatePath) {
+		t.Logf("duplicate path wasn't reported: %v", err)
+		t.FailNow()
+	}
+}
+
+func TestSeekAndReadAt(t *testing.T) {
+	data := encodeTestPackage(t, func(e *JPkgEncoder) {
+		e.Compression = &jpkg_impl.LZWCompressionHandler{}
+		e.ChunkSize = 1000
+	})
+
+	pkg, err := ReadJPkg(bytes.NewReader(data), nil)
+	if err != nil {
+		t.Logf("error reading package: %v", err.Error())
+		t.FailNow()
+	}
+
+	expected := testFiles[3].data
+
+	f, err := pkg.GetByPath(testFiles[3].path)
+	if err != nil {
+		t.Logf("error opening file: %v", err.Error())
+		t.FailNow()
+	}
+
+	if _, err := f.Seek(-2500, io.SeekEnd); err != nil {
+		t.Logf("error seeking: %v", err.Error())
+		t.FailNow()
+	}
+
+	tail, err := io.ReadAll(f)
+	if err != nil || !bytes.Equal(tail, expected[len(expected)-2500:]) {
+		t.Logf("reading after seek returned incorrect data: %v", err)
+		t.FailNow()
+	}
+
+	middle := make([]byte, 1500)
+	if _, err := f.ReadAt(middle, 1900); err != nil || !bytes.Equal(middle, expected[1900:3400]) {
+		t.Logf("read at returned incorrect data: %v", err)
+		t.FailNow()
+	}
+}
+
+func TestChunkSizeLimits(t *testing.T) {
+	for _, chunkSize := range []uint64{0, MAX_CHUNK_SIZE + 1, math.MaxUint64} {
+		encoder := NewJPkgEncoder(&bytes.Buffer{})
+		encoder.ChunkSize = chunkSize
+		encoder.AddFile(JPkgFileToEncode{Path: "/small.txt", Source: bytes.NewReader([]byte("small"))})
+
+		if err := encoder.Encode(); err == nil {
+			t.Logf("package was encoded with a chunk size of %v", chunkSize)
+			t.FailNow()
+		}
+	}
+
+	// small files only allocate what they hold, even with the largest chunk size
+	data := encodeTestPackage(t, func(e *JPkgEncoder) {
+		e.ChunkSize = MAX_CHUNK_SIZE
+	})
+
+	pkg, err := ReadJPkg(bytes.NewReader(data), nil)
+	if err != nil {
+		t.Logf("error reading package: %v", err.Error())
+		t.FailNow()
+	}
+
+	checkTestPackage(t, pkg)
+}
+
+func TestConcurrentReads(t *testing.T) {
+	data := encodeTestPackage(t, func(e *JPkgEncoder) {
+		e.ChunkSize = 512
+	})
+
+	pkg, err := ReadJPkgAt(bytes.NewReader(data), int64(len(data)), ReaderOptions{})
+	if err != nil {
+		t.Logf("error reading package: %v", err.Error())
+		t.FailNow()
+	}
+
+	wg := sync.WaitGroup{}
+	errs := make(chan error, 32)
+
+	for i := range 32 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			file := testFiles[i%len(testFiles)]
+			f, err := pkg.GetByPath(file.path)
+			if err != nil {
+				errs <- err
+				return
+			}
+			contents, err := io.ReadAll(f)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if !bytes.Equal(contents, file.data) {
+				errs <- fmt.Errorf("contents of %v incorrect", file.path)
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Logf("error during concurrent read: %v", err.Error())
+		t.FailNow()
+	}
+}
+
+func TestCloseDuringReads(t *testing.T) {
+	data := encodeTestPackage(t, func(e *JPkgEncoder) {
+		e.ChunkSize = 512
+	})
+
+	pkg, err := ReadJPkgAt(bytes.NewReader(data), int64(len(data)), ReaderOptions{})
+	if err != nil {
+		t.Logf("error reading package: %v", err.Error())
+		t.FailNow()
+	}
+
+	file := testFiles[len(testFiles)-1]
+	f, err := pkg.GetByPath(file.path)
+	if err != nil {
+		t.Logf("error opening %v: %v", file.path, err.Error())
+		t.FailNow()
+	}
+
+	wg := sync.WaitGroup{}
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b := make([]byte, 100)
+			for off := int64(i); off < int64(len(file.data)); off += 300 {
+				// reads after Close fail, but never with anything but fs.ErrClosed
+				if _, err := f.ReadAt(b, off); err != nil && !errors.Is(err, fs.ErrClosed) && err != io.EOF {
+					t.Errorf("error reading %v: %v", file.path, err)
+					return
+				}
+			}
+		}()
+	}
+
+	f.Close()
+	wg.Wait()
+}
+
+func TestBlockCacheDecodesOnce(t *testing.T) {
+	cache := &jpkgBlockCache{capacity: 2}
+	decodes := atomic.Int32{}
+	release := make(chan struct{})
+
+	slowDecode := func() ([]byte, error) {
+		decodes.Add(1)
+		<-release
+		return []byte("block"), nil
+	}
+
+	wg := sync.WaitGroup{}
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if data, err := cache.get(0, slowDecode); err != nil || string(data) != "block" {
+				t.Errorf("cached block incorrect: %v", err)
+			}
+		}()
+	}
+
+	// another block can be decoded while the first is still decoding
+	if data, err := cache.get(64, func() ([]byte, error) { return []byte("other"), nil }); err != nil || string(data) != "other" {
+		t.Logf("other block incorrect: %v", err)
+		t.FailNow()
+	}
+
+	close(release)
+	wg.Wait()
+
+	if decodes.Load() != 1 {
+		t.Logf("block decoded %v times", decodes.Load())
+		t.FailNow()
+	}
+}
+
+func TestReaderLimits(t *testing.T) {
+	// a header and a manifest claiming a package name of 2^60 characters
+	crafted := bytes.Buffer{}
+	jpkg_bin.BinaryWrite(&crafted, JPkgHeader{MagicNumber: MAGIC_NUMBER})
+	binary.Write(&crafted, binary.BigEndian, []uint64{0, 1, 1 << 60})
+
+	if _, err := ReadJPkgAt(bytes.NewReader(crafted.Bytes()), int64(crafted.Len()), ReaderOptions{}); err == nil {
+		t.Logf("crafted package was read")
+		t.FailNow()
+	}
+
+	data := encodeTestPackage(t, func(e *JPkgEncoder) {
+		e.Compression = &jpkg_impl.ZstdCompressionHandler{}
+	})
+
+	limits := []ReaderOptions{
+		{MaxStringLength: 5},
+		{MaxFileCount: 2},
+		{MaxUncompressedSize: 1024},
+		{MaxCompressionRatio: 2},
+	}
+
+	for _, options := range limits {
+		if _, err := ReadJPkgAt(bytes.NewReader(data), int64(len(data)), options); !errors.Is(err, ErrLimitExceeded) {
+			t.Logf("package was read despite %+v: %v", options, err)
+			t.FailNow()
+		}
+	}
+
+	if _, err := ReadJPkgAt(bytes.NewReader(data), int64(len(data)), ReaderOptions{MaxCompressionRatio: 1000}); err != nil {
+		t.Logf("error reading package within the limits: %v", err.Error())
+		t.FailNow()
+	}
+
+	// a few bytes claiming to be a petabyte, which the default limits refuse
+	bomb := forgedTestPackage(forgedBomb(1<<50), 1<<50)
+	if _, err := ReadJPkg(bytes.NewReader(bomb), nil); !errors.Is(err, ErrLimitExceeded) {
+		t.Logf("package claiming a petabyte was read: %v", err)
+		t.FailNow()
+	}
+
+	// without limits it's read, but only allocates what really decompresses
+	unlimited := ReaderOptions{MaxUncompressedSize: math.MaxUint64, MaxCompressionRatio: math.MaxUint64}
+	pkg, err := ReadJPkgAt(bytes.NewReader(bomb), int64(len(bomb)), unlimited)
+	if err != nil {
+		t.Logf("error reading package without limits: %v", err.Error())
+		t.FailNow()
+	}
+
+	f, err := pkg.Open("/forged.bin")
+	if err != nil {
+		t.Logf("error opening forged file: %v", err.Error())
+		t.FailNow()
+	}
+
+	var corrupt *ErrCorrupt
+	if _, err := io.ReadAll(f); !errors.As(err, &corrupt) {
+		t.Logf("chunk shorter than claimed wasn't reported as corrupt: %v", err)
+		t.FailNow()
+	}
+}
+
+func TestSignature(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Logf("error generating key: %v", err.Error())
+		t.FailNow()
+	}
+
+	data := encodeTestPackage(t, func(e *JPkgEncoder) {
+		e.Signer = &jpkg_impl.Ed25519CryptoHandler{PrivateKey: private}
+	})
+
+	pkg, err := ReadJPkgAt(bytes.NewReader(data), int64(len(data)), ReaderOptions{PublicKey: public})
+	if err != nil {
+		t.Logf("error reading signed package: %v", err.Error())
+		t.FailNow()
+	}
+
+	if !pkg.SignatureValid() {
+		t.Logf("signature should be valid")
+		t.FailNow()
+	}
+
+	checkTestPackage(t, pkg)
+
+	data[len(data)/2] ^= 0xFF
+
+	_, err = ReadJPkgAt(bytes.NewReader(data), int64(len(data)), ReaderOptions{PublicKey: public})
+	if !errors.Is(err, ErrSignatureInvalid) {
+		t.Logf("tampered package should fail verification: %v", err)
+		t.FailNow()
+	}
+}
+
+func TestIntegrity(t *testing.T) {
+	hashers := []jpkg_impl.HasherHandler{
+		&jpkg_impl.SHA256HasherHandler{},
+		&jpkg_impl.SHA512HasherHandler{},
+		&jpkg_impl.SHA3_256HasherHandler{},
+	}
+
+	for _, hasher := range hashers {
+		data := encodeTestPackage(t, func(e *JPkgEncoder) {
+			e.Hasher = hasher
+		})
+
+		pkg, err := ReadJPkg(bytes.NewReader(data), nil)
+		if err != nil {
+			t.Logf("error reading hashed package: %v", err.Error())
+			t.FailNow()
+		}
+
+		if err := pkg.VerifyIntegrity(); err != nil || !pkg.IntegrityValid() {
+			t.Logf("integrity should be valid for hasher %v: %v", hasher.Flag(), err)
+			t.FailNow()
+		}
+
+		checkTestPackage(t, pkg)
+
+		data[len(data)/2] ^= 0xFF
+
+		if err := pkg.VerifyIntegrity(); !errors.Is(err, ErrIntegrityMismatch) {
+			t.Logf("tampered package should fail integrity check for hasher %v: %v", hasher.Flag(), err)
+			t.FailNow()
+		}
+	}
+}
+
+func TestFileDigest(t *testing.T) {
+	data := encodeTestPackage(t, func(e *JPkgEncoder) {
+		e.Hasher = &jpkg_impl.SHA256HasherHandler{}
+	})
+
+	pkg, err := ReadJPkg(bytes.NewReader(data), nil)
+	if err != nil {
+		t.Logf("error reading package: %v", err.Error())
+		t.FailNow()
+	}
+
+	entries, err := pkg.ReadDir("/")
+	if err != nil {
+		t.Logf("error reading root directory: %v", err.Error())
+		t.FailNow()
+	}
+
+	expected := sha256.Sum256(testFiles[0].data)
+
+	for _, entry := range entries {
+		if entry.Name() != "readme.txt" {
+			continue
+		}
+		if !bytes.Equal(entry.(*JPkgDirInfo).Digest(), expected[:]) {
+			t.Logf("directory entry digest incorrect")
+			t.FailNow()
+		}
+	}
+
+	checkTestPackage(t, pkg)
+
+	corrupted := bytes.Clone(data)
+	corrupted[bytes.Index(corrupted, testFiles[0].data)] ^= 0xFF
+
+	pkg, err = ReadJPkg(bytes.NewReader(corrupted), nil)
+	if err != nil {
+		t.Logf("error reading corrupted package: %v", err.Error())
+		t.FailNow()
+	}
+
+	f, err := pkg.GetByPath(testFiles[0].path)
+	if err != nil {
+		t.Logf("error opening corrupted file: %v", err.Error())
+		t.FailNow()
+	}
+
+	if !bytes.Equal(f.Digest(), expected[:]) {
+		t.Logf("file digest incorrect")
+		t.FailNow()
+	}
+
+	if _, err := io.ReadAll(f); !errors.Is(err, ErrChecksumMismatch) {
+		t.Logf("reading corrupted file should fail with a checksum error: %v", err)
+		t.FailNow()
+	}
+}
@@ -1,8 +1,11 @@
 package jpkg
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"strings"
 	"time"
 
@@ -11,32 +14,141 @@ import (
 	jpkg_impl "github.com/j4d3blooded/JPkg/impl"
 )
 
+type ReaderOptions struct {
+	// the raw key, the passphrase for passphrase encrypted packages,
+	// or the X25519 private key for packages encrypted to recipients
+	EncryptionKey []byte
+	// if set, the package must be signed by the matching private key
+	// or reading fails with ErrSignatureInvalid
+	PublicKey []byte
+	// reads files stored without encryption in an encrypted package, even if the directory
+	// saying they're unencrypted isn't authenticated by an encrypted index or a verified
+	// signature. anyone able to edit the package can then replace them unnoticed
+	AllowUnencryptedFiles bool
+	// number of decoded solid blocks kept in memory, 0 keeps DEFAULT_SOLID_BLOCK_CACHE
+	SolidBlockCache int
+	// longest name, path or identifier accepted, in characters. 0 uses DEFAULT_MAX_STRING_LENGTH
+	MaxStringLength uint64
+	// longest package or file metadata accepted, in characters. 0 uses DEFAULT_MAX_METADATA_SIZE
+	MaxMetadataSize uint64
+	// most files accepted, 0 uses DEFAULT_MAX_FILE_COUNT
+	MaxFileCount uint64
+	// largest total uncompressed size of the files, 0 uses DEFAULT_MAX_UNCOMPRESSED_SIZE.
+	// math.MaxUint64 for no limit
+	MaxUncompressedSize uint64
+	// largest uncompressed size of a record or solid block per stored byte, so small records
+	// can't decompress to huge ones. 0 uses DEFAULT_MAX_COMPRESSION_RATIO, math.MaxUint64 for no limit
+	MaxCompressionRatio uint64
+}
+
+// reads a package starting at the current position of r. if r doesn't implement
+// io.ReaderAt, reads through it are serialized with a lock
 func ReadJPkg(r io.ReadSeeker, encryptionKey []byte) (*JPkg, error) {
+	start, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, fmt.Errorf("error seeking in file: %w", err)
+	}
+
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, fmt.Errorf("error seeking in file: %w", err)
+	}
+
+	ra, isReaderAt := r.(io.ReaderAt)
+	if !isReaderAt {
+		ra = &lockedReaderAt{r: r}
+	}
+
+	section := io.NewSectionReader(ra, start, end-start)
+	return ReadJPkgAt(section, section.Size(), ReaderOptions{EncryptionKey: encryptionKey})
+}
+
+// reads a package from the first size bytes of r. files opened from the package
+// read through their own io.SectionReader, so they can be used concurrently
+func ReadJPkgAt(ra io.ReaderAt, size int64, options ReaderOptions) (*JPkg, error) {
+	r := io.NewSectionReader(ra, 0, size)
 
 	header, err := parseHeader(r)
 	if err != nil {
 		return nil, fmt.Errorf("error reading header: %w", err)
 	}
 
-	manifest, err := parseManifest(r)
+	eHandler, err := jpkg_impl.GetEncryptionHandler(header.EncryptionFlag, options.EncryptionKey)
 	if err != nil {
-		return nil, fmt.Errorf("error reading manifest: %w", err)
+		return nil, err
 	}
 
-	files, err := parseFiles(r, manifest.FileCount)
+	hasher, err := jpkg_impl.GetHashHandler(header.HasherFlag)
 	if err != nil {
-		return nil, fmt.Errorf("error reading file records: %w", err)
+		return nil, err
 	}
 
+	signer, err := jpkg_impl.GetCryptoHandler(header.SignatureFlag, options.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	cHandler, err := jpkg_impl.GetCompressionHandler(header.CompressionFlag)
+	if err != nil {
+		return nil, err
+	}
+
+	footerSize := int64(hasher.Size() + signer.SignatureSize())
+
+	limits := options.limits()
+
+	index, err := parseIndex(r, header, eHandler, footerSize, limits)
+	if err != nil {
+		return nil, err
+	}
+	manifest, trailer, files := index.manifest, index.trailer, index.files
+
+	if index.dictionary != nil {
+		cHandler, err = loadDictionary(cHandler, index.dictionary)
+		if err != nil {
+			return nil, fmt.Errorf("error loading dictionary: %w", err)
+		}
+	}
+
+	context, err := packageContext(*header, *manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	solidBlocks, err := buildSolidBlocks(files, context, header.strings())
+	if err != nil {
+		return nil, fmt.Errorf("error reading solid blocks: %w", err)
+	}
+
+	if err := limits.checkFiles(files, solidBlocks, size); err != nil {
+		return nil, err
+	}
+
+	blockCacheSize := options.SolidBlockCache
+	if blockCacheSize <= 0 {
+		blockCacheSize = DEFAULT_SOLID_BLOCK_CACHE
+	}
+
+	// an encrypted index authenticates the directory, including which files are unencrypted
+	encryptedIndex := trailer != nil && trailer.Flags&TRAILER_FLAG_ENCRYPTED_INDEX != 0
+
 	pkg := &JPkg{
-		reader:         r,
-		cHandler:       jpkg_impl.GetCompressionHandler(header.CompressionFlag),
-		eHandler:       jpkg_impl.GetEncryptionHandler(header.EncryptionFlag, encryptionKey),
-		signatureValid: false,
-		integrityValid: false,
-		packagedAt:     time.Unix(manifest.PackagedAt, 0),
-		name:           manifest.PackageName,
-		metadata:       []byte(manifest.PackageMetadataJSON),
+		reader:             ra,
+		size:               size,
+		hHandler:           hasher,
+		sHandler:           signer,
+		cHandler:           cHandler,
+		eHandler:           eHandler,
+		chunkedRecords:     trailer != nil && trailer.Flags&TRAILER_FLAG_CHUNKED_RECORDS != 0,
+		solidBlocks:        solidBlocks,
+		blockCache:         &jpkgBlockCache{capacity: blockCacheSize},
+		context:            context,
+		strings:            header.strings(),
+		signatureValid:     false,
+		unencryptedAllowed: options.AllowUnencryptedFiles || encryptedIndex,
+		packagedAt:         time.Unix(manifest.PackagedAt, 0),
+		name:               manifest.PackageName,
+		metadata:           []byte(manifest.PackageMetadataJSON),
 	}
 
 	fileOpeners, directoryOpeners, err := buildFS(files)
@@ -47,43 +159,443 @@ func ReadJPkg(r io.ReadSeeker, encryptionKey []byte) (*JPkg, error) {
 	pkg.pathsToFiles = fileOpeners
 	pkg.pathsToDirectories = directoryOpeners
 
+	if options.PublicKey != nil {
+		if err := verifySignature(r, signer); err != nil {
+			return nil, err
+		}
+		pkg.signatureValid = true
+		pkg.unencryptedAllowed = true
+	}
+
 	return pkg, nil
 }
 
+func verifySignature(r *io.SectionReader, signer jpkg_impl.CryptoHandler) error {
+	if signer.Flag() == jpkg_impl.CRYPTO_NONE {
+		return fmt.Errorf("%w: package is not signed", ErrSignatureInvalid)
+	}
+
+	signatureSize := int64(signer.SignatureSize())
+	if r.Size() < signatureSize {
+		return fmt.Errorf("%w: package is too small to be signed", ErrSignatureInvalid)
+	}
+
+	digest := signer.NewHash()
+	if _, err := io.Copy(digest, io.NewSectionReader(r, 0, r.Size()-signatureSize)); err != nil {
+		return fmt.Errorf("error hashing package: %w", err)
+	}
+
+	signature := make([]byte, signatureSize)
+	if _, err := r.ReadAt(signature, r.Size()-signatureSize); err != nil {
+		return fmt.Errorf("error reading signature: %w", err)
+	}
+
+	if !signer.Verify(digest.Sum(nil), signature) {
+		return ErrSignatureInvalid
+	}
+
+	return nil
+}
+
 func parseHeader(r io.ReadSeeker) (*JPkgHeader, error) {
 	header, err := jpkg_bin.BinaryRead[JPkgHeader](r)
-
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, fmt.Errorf("%w: too short for a header", ErrBadMagic)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("error reading jpkg header: %w", err)
 	}
 
 	if header.MagicNumber != MAGIC_NUMBER {
-		return nil, fmt.Errorf("incorrect magic number")
+		return nil, ErrBadMagic
+	}
+
+	if header.Version != FORMAT_VERSION_0 && header.Version != FORMAT_VERSION {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedVersion, header.Version)
 	}
 
-	if header.Version != 0 {
-		return nil, fmt.Errorf("unsupported version: %v", header.Version)
+	if err := skipPadding(r, header); err != nil {
+		return nil, fmt.Errorf("error reading header padding: %w", err)
 	}
 
 	return header, nil
 }
 
-func parseManifest(r io.ReadSeeker) (*JPkgManifest, error) {
-	header, err := jpkg_bin.BinaryRead[JPkgManifest](r)
+type jpkgIndex struct {
+	manifest *JPkgManifest
+	trailer  *JPkgTrailer
+	files    []JPkgFileRecordWithOffset
+	// nil unless the package stores a dictionary
+	dictionary []byte
+	// where the encryption parameters and the file records start
+	headerEnd int64
+	bodyStart int64
+}
+
+// reads everything after the header needed to find the files, from either the
+// plaintext manifest and directory or the encrypted index
+func parseIndex(
+	r *io.SectionReader, header *JPkgHeader, eHandler jpkg_impl.EncryptionHandler, footerSize int64, limits jpkgLimits,
+) (*jpkgIndex, error) {
+	index := &jpkgIndex{}
+	index.headerEnd, _ = r.Seek(0, io.SeekCurrent)
+
+	if err := parseEncryptionParameters(r, header, eHandler); err != nil {
+		return nil, fmt.Errorf("error reading encryption parameters: %w", asCorrupt(index.headerEnd, -1, err))
+	}
+
+	index.bodyStart, _ = r.Seek(0, io.SeekCurrent)
+
+	trailer, err := parseTrailer(r, footerSize)
+	if err != nil {
+		return nil, fmt.Errorf("error reading trailer: %w", err)
+	}
+	index.trailer = trailer
+
+	if trailer != nil && trailer.Flags&TRAILER_FLAG_ENCRYPTED_INDEX != 0 {
+		if err := parseEncryptedIndex(r, header, index, eHandler, limits); err != nil {
+			return nil, fmt.Errorf("error reading encrypted index: %w", err)
+		}
+		return index, nil
+	}
+
+	index.manifest, err = parseManifest(r, header, limits)
+	if err != nil {
+		return nil, fmt.Errorf("error reading manifest: %w", asCorrupt(index.bodyStart, -1, err))
+	}
+
+	if trailer != nil {
+		if err := parseDirectory(r, header, index, limits); err != nil {
+			return nil, fmt.Errorf("error reading central directory: %w", err)
+		}
+	} else { // packages without a trailer need every record scanned
+		index.files, err = parseFiles(r, header, index.manifest.FileCount, limits)
+		if err != nil {
+			return nil, fmt.Errorf("error reading file records: %w", err)
+		}
+	}
+
+	return index, nil
+}
+
+func parseEncryptionParameters(r io.ReadSeeker, header *JPkgHeader, handler jpkg_impl.EncryptionHandler) error {
+	parameterized, isParameterized := handler.(jpkg_impl.ParameterizedEncryptionHandler)
+	if !isParameterized || !header.aesKeyChecked() {
+		return nil
+	}
 
+	parameters, err := jpkg_bin.BinaryRead[JPkgEncryptionParameters](r)
+	if err != nil {
+		return fmt.Errorf("error reading jpkg encryption parameters: %w", err)
+	}
+
+	if err := skipPadding(r, header); err != nil {
+		return fmt.Errorf("error reading encryption parameters padding: %w", err)
+	}
+
+	return parameterized.LoadParameters(parameters.Parameters)
+}
+
+func parseManifest(r io.ReadSeeker, header *JPkgHeader, limits jpkgLimits) (*JPkgManifest, error) {
+	manifest, err := readLimited[JPkgManifest](r, header, limits)
 	if err != nil {
 		return nil, fmt.Errorf("error reading jpkg manifest: %w", err)
 	}
 
-	return header, nil
+	if err := limits.checkManifest(manifest); err != nil {
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+// returns nil without an error if the package has no trailer. the trailer sits right
+// before the footer, whose size is known from the header flags
+func parseTrailer(r io.ReadSeeker, footerSize int64) (*JPkgTrailer, error) {
+	start, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, fmt.Errorf("error seeking in file: %w", err)
+	}
+
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, fmt.Errorf("error seeking in file: %w", err)
+	}
+
+	trailerEnd := end - footerSize
+
+	if trailerEnd-start < TRAILER_SIZE {
+		_, err = r.Seek(start, io.SeekStart)
+		return nil, err
+	}
+
+	if _, err := r.Seek(trailerEnd-TRAILER_SIZE, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("error seeking in file: %w", err)
+	}
+
+	trailer, err := jpkg_bin.BinaryRead[JPkgTrailer](r)
+	if err != nil {
+		return nil, fmt.Errorf("error reading trailer: %w", err)
+	}
+
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("error seeking in file: %w", err)
+	}
+
+	if trailer.MagicNumber != TRAILER_MAGIC_NUMBER {
+		return nil, nil
+	}
+
+	// compared without adding, so a huge size can't wrap around to the trailer's offset
+	directoryEnd := uint64(trailerEnd - TRAILER_SIZE)
+	if trailer.DirectoryOffset < uint64(start) || trailer.DirectoryOffset > directoryEnd ||
+		trailer.DirectorySize != directoryEnd-trailer.DirectoryOffset {
+		return nil, nil
+	}
+
+	return trailer, nil
+}
+
+func parseDirectory(r io.ReadSeeker, header *JPkgHeader, index *jpkgIndex, limits jpkgLimits) error {
+	trailer := index.trailer
+
+	if _, err := r.Seek(int64(trailer.DirectoryOffset), io.SeekStart); err != nil {
+		return fmt.Errorf("error seeking to directory: %w", err)
+	}
+
+	directory := make([]byte, trailer.DirectorySize)
+	if _, err := io.ReadFull(r, directory); err != nil {
+		return fmt.Errorf("error reading directory: %w", asCorrupt(int64(trailer.DirectoryOffset), -1, err))
+	}
+
+	dr := bytes.NewReader(directory)
+
+	dictionary, err := readDirectoryDictionary(dr, trailer, int64(trailer.DirectoryOffset))
+	if err != nil {
+		return err
+	}
+
+	files, err := readDirectoryEntries(dr, header, trailer, index.manifest.FileCount, limits, int64(trailer.DirectoryOffset))
+	if err != nil {
+		return err
+	}
+
+	index.files, index.dictionary = files, dictionary
+	return nil
+}
+
+// the encrypted index holds the manifest followed by the central directory, compressed
+// then encrypted as a single chunk
+func parseEncryptedIndex(
+	r *io.SectionReader, header *JPkgHeader, index *jpkgIndex, eHandler jpkg_impl.EncryptionHandler, limits jpkgLimits,
+) error {
+	trailer := index.trailer
+
+	associatedData, err := indexAssociatedData(*header)
+	if err != nil {
+		return err
+	}
+
+	encrypted := io.NewSectionReader(r, int64(trailer.DirectoryOffset), int64(trailer.DirectorySize))
+	compressed, compressedSize, err := eHandler.DecryptAt(encrypted, encrypted.Size(), associatedData)
+	if err != nil {
+		return fmt.Errorf("error creating decryptor: %w", err)
+	}
+
+	cHandler, err := jpkg_impl.GetCompressionHandler(header.CompressionFlag)
+	if err != nil {
+		return err
+	}
+
+	decompressor, err := cHandler.DecompressReader(io.NewSectionReader(compressed, 0, compressedSize))
+	if err != nil {
+		return fmt.Errorf("error creating decompressor: %w", &ErrCorrupt{int64(trailer.DirectoryOffset), -1, err})
+	}
+	defer decompressor.Close()
+
+	// index entries are small and compress well, but still can't be more than the ratio allows
+	var decompressed io.Reader = decompressor
+	if uint64(compressedSize) < math.MaxInt64/limits.maxCompressionRatio {
+		decompressed = io.LimitReader(decompressor, compressedSize*int64(limits.maxCompressionRatio)+1)
+	}
+
+	data, err := io.ReadAll(decompressed)
+	if err != nil {
+		return fmt.Errorf("error decoding index: %w", &ErrCorrupt{int64(trailer.DirectoryOffset), -1, err})
+	}
+
+	if !limits.ratioAllowed(uint64(len(data)), uint64(compressedSize)) {
+		return fmt.Errorf("%w: index is compressed more than %v times", ErrLimitExceeded, limits.maxCompressionRatio)
+	}
+
+	ir := bytes.NewReader(data)
+
+	manifest, err := readLimited[JPkgManifest](ir, header, limits)
+	if err != nil {
+		return fmt.Errorf("error reading jpkg manifest: %w", asCorrupt(int64(trailer.DirectoryOffset), -1, err))
+	}
+
+	if err := limits.checkManifest(manifest); err != nil {
+		return err
+	}
+
+	dictionary, err := readDirectoryDictionary(ir, trailer, -1)
+	if err != nil {
+		return err
+	}
+
+	files, err := readDirectoryEntries(ir, header, trailer, manifest.FileCount, limits, -1)
+	if err != nil {
+		return err
+	}
+
+	index.manifest, index.files, index.dictionary = manifest, files, dictionary
+	return nil
+}
+
+// the offset in the package of where dr is, when dr holds the directory as it's stored
+// from base. -1 for directories that were decrypted
+func directoryPosition(dr *bytes.Reader, base int64) int64 {
+	if base < 0 {
+		return -1
+	}
+	return base + dr.Size() - int64(dr.Len())
+}
+
+func readDirectoryDictionary(dr *bytes.Reader, trailer *JPkgTrailer, base int64) ([]byte, error) {
+	if trailer.Flags&TRAILER_FLAG_DICTIONARY == 0 {
+		return nil, nil
+	}
+
+	position := directoryPosition(dr, base)
+
+	dictionary, err := jpkg_bin.BinaryRead[JPkgDictionary](dr)
+	if err != nil {
+		return nil, fmt.Errorf("error reading dictionary: %w", asCorrupt(position, -1, err))
+	}
+
+	return dictionary.Dictionary, nil
+}
+
+func readDirectoryEntries(
+	dr *bytes.Reader, header *JPkgHeader, trailer *JPkgTrailer, fileCount uint64, limits jpkgLimits, base int64,
+) ([]JPkgFileRecordWithOffset, error) {
+	if err := checkFileCount(fileCount, uint64(dr.Len()), minimumSize[jpkgLegacyDirectoryEntry]()); err != nil {
+		return nil, &ErrCorrupt{directoryPosition(dr, base), -1, err}
+	}
+
+	files := make([]JPkgFileRecordWithOffset, fileCount)
+
+	for i := range fileCount {
+		position := directoryPosition(dr, base)
+
+		entry, err := readDirectoryEntry(dr, header, trailer, limits)
+		if err != nil {
+			return nil, fmt.Errorf("error reading directory entry %v: %w", i, asCorrupt(position, int64(i), err))
+		}
+
+		if err := limits.checkRecord(&entry.JPkgFileRecordWithoutData); err != nil {
+			return nil, fmt.Errorf("error reading directory entry %v: %w", i, err)
+		}
+
+		if entry.EncryptionFlag != jpkg_impl.ENCRYPTION_NONE && entry.EncryptionFlag != header.EncryptionFlag {
+			return nil, newCorrupt(
+				position, int64(i), "directory entry is encrypted with %v, but the package key is for %v", entry.EncryptionFlag, header.EncryptionFlag,
+			)
+		}
+
+		entry.index = i
+		files[i] = *entry
+	}
+
+	if dr.Len() != 0 {
+		return nil, newCorrupt(directoryPosition(dr, base), -1, "directory has %v trailing bytes", dr.Len())
+	}
+
+	return files, nil
 }
 
-func parseFiles(r io.ReadSeeker, fileCount uint64) ([]JPkgFileRecordWithOffset, error) {
+func readDirectoryEntry(dr *bytes.Reader, header *JPkgHeader, trailer *JPkgTrailer, limits jpkgLimits) (*JPkgFileRecordWithOffset, error) {
+	entry, err := readDirectoryEntryFields(dr, header, trailer, limits)
+	if err != nil {
+		return nil, err
+	}
+
+	if trailer.Flags&TRAILER_FLAG_SOLID_BLOCKS == 0 {
+		return entry, nil
+	}
+
+	reference, err := jpkg_bin.BinaryRead[JPkgSolidBlockReference](dr)
+	if err != nil {
+		return nil, fmt.Errorf("error reading solid block reference: %w", err)
+	}
+
+	if reference.BlockOffset != NOT_IN_SOLID_BLOCK {
+		entry.block = reference
+	}
+
+	return entry, nil
+}
+
+func readDirectoryEntryFields(dr *bytes.Reader, header *JPkgHeader, trailer *JPkgTrailer, limits jpkgLimits) (*JPkgFileRecordWithOffset, error) {
+	if trailer.Flags&TRAILER_FLAG_RECORD_FLAGS != 0 {
+		return readLimited[JPkgFileRecordWithOffset](dr, header, limits)
+	}
+
+	entry, err := readLimited[jpkgLegacyDirectoryEntry](dr, header, limits)
+	if err != nil {
+		return nil, err
+	}
+
+	return &JPkgFileRecordWithOffset{
+		JPkgFileRecordWithoutData: entry.JPkgFileRecordWithoutData,
+		Offset:                    entry.Offset,
+		Digest:                    entry.Digest,
+		JPkgRecordFlags:           JPkgRecordFlags{header.CompressionFlag, header.EncryptionFlag},
+	}, nil
+}
+
+func parseFiles(r io.ReadSeeker, header *JPkgHeader, fileCount uint64, limits jpkgLimits) ([]JPkgFileRecordWithOffset, error) {
+	start, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, fmt.Errorf("error seeking in file: %w", err)
+	}
+
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, fmt.Errorf("error seeking in file: %w", err)
+	}
+
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("error seeking in file: %w", err)
+	}
+
+	if err := checkFileCount(fileCount, uint64(end-start), minimumSize[JPkgFileRecordWithoutData]()); err != nil {
+		return nil, &ErrCorrupt{start, -1, err}
+	}
+
 	files := make([]JPkgFileRecordWithOffset, fileCount)
 
 	for i := range fileCount {
-		record, err := jpkg_bin.BinaryRead[JPkgFileRecordWithoutData](r)
+		position, err := r.Seek(0, io.SeekCurrent)
 		if err != nil {
+			return nil, fmt.Errorf("error seeking in file: %w", err)
+		}
+
+		record, err := readLimited[JPkgFileRecordWithoutData](r, header, limits)
+		if err != nil {
+			return nil, fmt.Errorf("error reading file record %v: %w", i, asCorrupt(position, int64(i), err))
+		}
+
+		if err := limits.checkRecord(record); err != nil {
+			return nil, fmt.Errorf("error reading file record %v: %w", i, err)
+		}
+
+		if record.CompressedDataSize == UNKNOWN_SIZE {
+			return nil, newCorrupt(position, int64(i), "file record has no size, and the package has no central directory")
+		}
+
+		if err := skipPadding(r, header); err != nil {
 			return nil, fmt.Errorf("error reading file record %v: %w", i, err)
 		}
 
@@ -92,6 +604,10 @@ func parseFiles(r io.ReadSeeker, fileCount uint64) ([]JPkgFileRecordWithOffset,
 			return nil, fmt.Errorf("error seeking in file: %w", err)
 		}
 
+		if record.CompressedDataSize > uint64(end-offset) {
+			return nil, newCorrupt(position, int64(i), "file record is larger than the package")
+		}
+
 		_, err = r.Seek(int64(record.CompressedDataSize), io.SeekCurrent)
 		if err != nil {
 			return nil, fmt.Errorf("error seeking in file: %w", err)
@@ -100,6 +616,8 @@ func parseFiles(r io.ReadSeeker, fileCount uint64) ([]JPkgFileRecordWithOffset,
 		files[i] = JPkgFileRecordWithOffset{
 			JPkgFileRecordWithoutData: *record,
 			Offset:                    uint64(offset),
+			JPkgRecordFlags:           JPkgRecordFlags{header.CompressionFlag, header.EncryptionFlag},
+			index:                     i,
 		}
 	}
 
@@ -111,7 +629,7 @@ func buildFS(files []JPkgFileRecordWithOffset) (map[string]jpkgFileOpenerInfo, m
 
 	for _, file := range files {
 		if _, exists := paths[file.FilePath]; exists {
-			return nil, nil, fmt.Errorf("filepath %v is in use more then once", file.FilePath)
+			return nil, nil, &ErrCorrupt{-1, int64(file.index), fmt.Errorf("%w: %v", ErrDuplicatePath, file.FilePath)}
 		}
 		paths[file.FilePath] = file
 	}
@@ -128,7 +646,7 @@ func buildFS(files []JPkgFileRecordWithOffset) (map[string]jpkgFileOpenerInfo, m
 
 	for path := range paths {
 		if err := convertPathToNodeTreeBranch(path, treeRoot.Root, pathsToNodes); err != nil {
-			return nil, nil, err
+			return nil, nil, &ErrCorrupt{-1, int64(paths[path].index), err}
 		}
 	}
 
@@ -136,7 +654,9 @@ func buildFS(files []JPkgFileRecordWithOffset) (map[string]jpkgFileOpenerInfo, m
 	dirs := map[string]jpkgDirOpenerInfo{}
 
 	for path, node := range pathsToNodes {
-		convertNodeToOpenerInfo(node, path, dirs, fils, paths)
+		if err := convertNodeToOpenerInfo(node, path, dirs, fils, paths); err != nil {
+			return nil, nil, err
+		}
 	}
 
 	return fils, dirs, nil
@@ -185,7 +705,7 @@ outer:
 
 	for _, child := range dir.Children {
 		if child.GetName() == lastSeg {
-			return fmt.Errorf("filename of path is already in use as either directory or file: %v", path)
+			return fmt.Errorf("%w: filename of path is already in use as either directory or file: %v", ErrDuplicatePath, path)
 		}
 	}
 
@@ -199,11 +719,18 @@ outer:
 	return nil
 }
 
+func solidBlockOffset(file JPkgFileRecordWithOffset) uint64 {
+	if file.block == nil {
+		return 0
+	}
+	return file.block.BlockOffset
+}
+
 func convertNodeToOpenerInfo(
 	node jpkg_fs.JPkgFSNode, path string,
 	directories map[string]jpkgDirOpenerInfo, files map[string]jpkgFileOpenerInfo,
 	paths map[string]JPkgFileRecordWithOffset,
-) {
+) error {
 	switch f := node.(type) {
 	case *jpkg_fs.JPkgFSDirectory:
 
@@ -228,9 +755,17 @@ func convertNodeToOpenerInfo(
 			uuid:             paths[path].UUID,
 			offset:           int64(paths[path].Offset),
 			metadata:         []byte(paths[path].FileMetadataJSON),
+			digest:           paths[path].Digest,
+			index:            paths[path].index,
+			compressionFlag:  paths[path].CompressionFlag,
+			encryptionFlag:   paths[path].EncryptionFlag,
+			solid:            paths[path].block != nil,
+			blockOffset:      solidBlockOffset(paths[path]),
 		}
 
 	default:
-		panic("unsupported fs node type")
+		return fmt.Errorf("unsupported fs node type %T", node)
 	}
+
+	return nil
 }
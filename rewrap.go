@@ -0,0 +1,103 @@
+package jpkg
+
+import (
+	"crypto/ecdh"
+	"errors"
+	"fmt"
+	"io"
+
+	jpkg_impl "github.com/j4d3blooded/JPkg/impl"
+)
+
+// copies a package encrypted for a set of recipients to w, with its content key wrapped
+// for a new set of recipients instead. file data is copied as is, only the encryption
+// parameters, central directory (or encrypted index) and footer are written again.
+// privateKey must belong to one of the current recipients, and a signed package needs
+// signer to sign the copy
+func RewrapRecipients(
+	r io.ReaderAt, size int64, w io.Writer,
+	privateKey *ecdh.PrivateKey, recipients []*ecdh.PublicKey, signer jpkg_impl.CryptoHandler,
+) error {
+	sr := io.NewSectionReader(r, 0, size)
+
+	header, err := parseHeader(sr)
+	if err != nil {
+		return fmt.Errorf("error reading header: %w", err)
+	}
+
+	if header.EncryptionFlag != jpkg_impl.ENCRYPTION_X25519 {
+		return errors.New("package is not encrypted for recipients")
+	}
+
+	if header.SignatureFlag == jpkg_impl.CRYPTO_NONE {
+		signer = &jpkg_impl.NullCryptoHandler{}
+	} else if signer == nil || signer.Flag() != header.SignatureFlag {
+		return errors.New("package is signed, a signer with the same flag is needed")
+	}
+
+	handler := &jpkg_impl.RecipientsEncryptionHandler{PrivateKey: privateKey}
+	hasher, err := jpkg_impl.GetHashHandler(header.HasherFlag)
+	if err != nil {
+		return err
+	}
+
+	footerSize := int64(hasher.Size() + signer.SignatureSize())
+
+	index, err := parseIndex(sr, header, handler, footerSize, ReaderOptions{}.limits())
+	if err != nil {
+		return err
+	}
+	if index.trailer == nil {
+		return errors.New("package has no central directory")
+	}
+
+	trailer, files := index.trailer, index.files
+	headerEnd, bodyStart := index.headerEnd, index.bodyStart
+
+	handler.Recipients = recipients
+
+	compression, err := jpkg_impl.GetCompressionHandler(header.CompressionFlag)
+	if err != nil {
+		return err
+	}
+
+	encoder := NewJPkgEncoder(w)
+	encoder.Encryption = handler
+	encoder.Compression = compression
+	encoder.Dictionary = index.dictionary
+	encoder.Hasher = hasher
+	encoder.Signer = signer
+	encoder.header = *header
+	encoder.manifest = *index.manifest
+	encoder.hashOutput()
+
+	if _, err := io.Copy(encoder.w, io.NewSectionReader(r, 0, headerEnd)); err != nil {
+		return fmt.Errorf("error copying header: %w", err)
+	}
+
+	if err := encoder.writeEncryptionParameters(); err != nil {
+		return fmt.Errorf("error writing encryption parameters: %w", err)
+	}
+
+	shift := int64(encoder.w.count) - bodyStart
+
+	body := io.NewSectionReader(r, bodyStart, int64(trailer.DirectoryOffset)-bodyStart)
+	if _, err := io.Copy(encoder.w, body); err != nil {
+		return fmt.Errorf("error copying file records: %w", err)
+	}
+
+	for i := range files {
+		files[i].Offset = uint64(int64(files[i].Offset) + shift)
+	}
+	encoder.directory = files
+
+	if err := encoder.writeDirectory(trailer.Flags); err != nil {
+		return fmt.Errorf("error writing central directory: %w", err)
+	}
+
+	if err := encoder.writeFooter(); err != nil {
+		return fmt.Errorf("error writing footer: %w", err)
+	}
+
+	return nil
+}
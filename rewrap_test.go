@@ -0,0 +1,97 @@
+package jpkg
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	jpkg_impl "github.com/j4d3blooded/JPkg/impl"
+)
+
+func TestRecipients(t *testing.T) {
+	testRecipients(t, false)
+}
+
+func TestRecipientsEncryptedIndex(t *testing.T) {
+	testRecipients(t, true)
+}
+
+func testRecipients(t *testing.T, encryptIndex bool) {
+	keys := make([]*ecdh.PrivateKey, 3)
+	for i := range keys {
+		key, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			t.Logf("error generating key: %v", err.Error())
+			t.FailNow()
+		}
+		keys[i] = key
+	}
+
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Logf("error generating signing key: %v", err.Error())
+		t.FailNow()
+	}
+	signer := &jpkg_impl.Ed25519CryptoHandler{PrivateKey: private}
+
+	data := encodeTestPackage(t, func(e *JPkgEncoder) {
+		e.Encryption = &jpkg_impl.RecipientsEncryptionHandler{
+			Recipients: []*ecdh.PublicKey{keys[0].PublicKey(), keys[1].PublicKey()},
+		}
+		e.Hasher = &jpkg_impl.SHA256HasherHandler{}
+		e.Signer = signer
+		e.EncryptIndex = encryptIndex
+	})
+
+	for i, key := range keys {
+		pkg, err := ReadJPkg(bytes.NewReader(data), key.Bytes())
+		if i == 2 {
+			if !errors.Is(err, ErrWrongKey) {
+				t.Logf("reading as a non recipient should fail with ErrWrongKey: %v", err)
+				t.FailNow()
+			}
+			continue
+		}
+		if err != nil {
+			t.Logf("error reading package as recipient %v: %v", i, err.Error())
+			t.FailNow()
+		}
+		checkTestPackage(t, pkg)
+	}
+
+	rewrapped := bytes.Buffer{}
+	err = RewrapRecipients(
+		bytes.NewReader(data), int64(len(data)), &rewrapped,
+		keys[1], []*ecdh.PublicKey{keys[1].PublicKey(), keys[2].PublicKey()}, signer,
+	)
+	if err != nil {
+		t.Logf("error rewrapping package: %v", err.Error())
+		t.FailNow()
+	}
+
+	for i, key := range keys {
+		pkg, err := ReadJPkgAt(bytes.NewReader(rewrapped.Bytes()), int64(rewrapped.Len()), ReaderOptions{
+			EncryptionKey: key.Bytes(),
+			PublicKey:     public,
+		})
+		if i == 0 {
+			if !errors.Is(err, ErrWrongKey) {
+				t.Logf("removed recipient should fail with ErrWrongKey: %v", err)
+				t.FailNow()
+			}
+			continue
+		}
+		if err != nil {
+			t.Logf("error reading rewrapped package as recipient %v: %v", i, err.Error())
+			t.FailNow()
+		}
+		if err := pkg.VerifyIntegrity(); err != nil {
+			t.Logf("rewrapped package failed integrity check: %v", err.Error())
+			t.FailNow()
+		}
+		checkTestPackage(t, pkg)
+	}
+}
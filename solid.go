@@ -0,0 +1,337 @@
+package jpkg
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"sync"
+
+	jpkg_bin "github.com/j4d3blooded/JPkg/bin"
+)
+
+type jpkgSolidMemberIdentity struct {
+	Index       uint64
+	UUID        UUID
+	Path        string
+	Identifier  string
+	Metadata    string
+	BlockOffset uint64
+	Size        uint64
+}
+
+type jpkgSolidBlockIdentity struct {
+	Context []byte
+	// SHA-256 of the identity and placement of every file in the block
+	Members []byte
+}
+
+// a block isn't a record, so it's bound to every file in it instead
+func blockAssociatedData(context []byte, members []JPkgFileRecordWithOffset, encoding jpkg_bin.StringEncoding) ([]byte, error) {
+	digest := sha256.New()
+
+	for _, member := range members {
+		identity := jpkgSolidMemberIdentity{
+			Index:       member.index,
+			UUID:        member.UUID,
+			Path:        member.FilePath,
+			Identifier:  member.FileIdentifier,
+			Metadata:    member.FileMetadataJSON,
+			BlockOffset: member.block.BlockOffset,
+			Size:        member.UncompressedDataSize,
+		}
+		if err := jpkg_bin.BinaryWriteEncoded(digest, identity, encoding); err != nil {
+			return nil, fmt.Errorf("error writing solid block member identity: %w", err)
+		}
+	}
+
+	b := bytes.Buffer{}
+	if err := jpkg_bin.BinaryWrite(&b, jpkgSolidBlockIdentity{context, digest.Sum(nil)}); err != nil {
+		return nil, fmt.Errorf("error writing solid block identity: %w", err)
+	}
+	return b.Bytes(), nil
+}
+
+// adds the file to the current solid block if it's no larger than SolidBlockSize. otherwise
+// returns a reader that still starts at the beginning of the file
+func (j *JPkgEncoder) addToSolidBlock(p *jpkgPipeline, file jpkgFileRecord, index uint64) (bool, io.Reader, error) {
+	data := bytes.Buffer{}
+	n, err := io.CopyN(&data, file.source, int64(j.SolidBlockSize)+1)
+	if err != nil && err != io.EOF {
+		return false, nil, fmt.Errorf("error reading file data: %w", err)
+	}
+
+	if uint64(n) > j.SolidBlockSize {
+		return false, io.MultiReader(&data, file.source), nil
+	}
+
+	if uint64(j.solidBlock.Len())+uint64(n) > j.SolidBlockSize {
+		if err := j.flushSolidBlock(p); err != nil {
+			return false, nil, err
+		}
+	}
+
+	var digest []byte
+	if h := j.Hasher.New(); h != nil {
+		h.Write(data.Bytes())
+		digest = h.Sum(nil)
+	}
+
+	j.solidMembers = append(j.solidMembers, JPkgFileRecordWithOffset{
+		JPkgFileRecordWithoutData: JPkgFileRecordWithoutData{
+			FileIdentifier:       file.identifier,
+			FilePath:             file.path,
+			UUID:                 file.uuid,
+			FileMetadataJSON:     file.metadataJson,
+			UncompressedDataSize: uint64(n),
+		},
+		Digest:          digest,
+		JPkgRecordFlags: JPkgRecordFlags{j.Compression.Flag(), j.Encryption.Flag()},
+		index:           index,
+		block:           &JPkgSolidBlockReference{BlockOffset: uint64(j.solidBlock.Len())},
+	})
+
+	j.solidBlock.Write(data.Bytes())
+	return true, nil, nil
+}
+
+// queues the current solid block as a single chunk, followed by the directory entries of its files
+func (j *JPkgEncoder) flushSolidBlock(p *jpkgPipeline) error {
+	if len(j.solidMembers) == 0 {
+		return nil
+	}
+
+	members := j.solidMembers
+	associatedData, err := blockAssociatedData(j.context, members, j.header.strings())
+	if err != nil {
+		return err
+	}
+
+	compression, err := j.withDictionary(j.Compression)
+	if err != nil {
+		return err
+	}
+
+	// the block is encoded while the next one is filled
+	block := j.solidBlock.Bytes()
+	j.solidBlock = bytes.Buffer{}
+	j.solidMembers = nil
+
+	err = j.queueRecordData(
+		p, "solid block", bytes.NewReader(block), associatedData, max(uint64(len(block)), 1), compression, j.Encryption,
+		func(offset, _ uint64, _ []byte) error {
+			for _, member := range members {
+				member.Offset = offset
+				member.CompressedDataSize = j.w.count - offset
+				j.directory = append(j.directory, member)
+			}
+			return nil
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("error writing solid block: %w", err)
+	}
+
+	return nil
+}
+
+type jpkgSolidBlock struct {
+	offset         int64
+	storedSize     uint64
+	size           uint64
+	flags          JPkgRecordFlags
+	associatedData []byte
+}
+
+// groups the files in solid blocks by the block they're in. files have to fill their
+// block in order, without gaps
+func buildSolidBlocks(files []JPkgFileRecordWithOffset, context []byte, encoding jpkg_bin.StringEncoding) (map[int64]*jpkgSolidBlock, error) {
+	members := map[uint64][]JPkgFileRecordWithOffset{}
+	for _, file := range files {
+		if file.block != nil {
+			members[file.Offset] = append(members[file.Offset], file)
+		}
+	}
+
+	blocks := map[int64]*jpkgSolidBlock{}
+
+	for offset, files := range members {
+		block := &jpkgSolidBlock{
+			offset:     int64(offset),
+			storedSize: files[0].CompressedDataSize,
+			flags:      files[0].JPkgRecordFlags,
+		}
+
+		for _, file := range files {
+			if file.CompressedDataSize != block.storedSize || file.JPkgRecordFlags != block.flags {
+				return nil, newCorrupt(int64(offset), int64(file.index), "files in the solid block at %v disagree on its size or flags", offset)
+			}
+			if file.block.BlockOffset != block.size {
+				return nil, newCorrupt(int64(offset), int64(file.index), "file %v isn't where expected in the solid block at %v", file.FilePath, offset)
+			}
+			block.size += file.UncompressedDataSize
+		}
+
+		associatedData, err := blockAssociatedData(context, files, encoding)
+		if err != nil {
+			return nil, err
+		}
+		block.associatedData = associatedData
+
+		blocks[block.offset] = block
+	}
+
+	return blocks, nil
+}
+
+func (j *JPkg) decodeSolidBlock(block *jpkgSolidBlock) ([]byte, error) {
+	data := io.NewSectionReader(j.reader, block.offset, int64(block.storedSize))
+
+	chunks, err := j.readChunkTable(data, jpkgFileOpenerInfo{
+		compressedSize:   block.storedSize,
+		uncompressedSize: block.size,
+		offset:           block.offset,
+		solid:            true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading chunk table: %w", err)
+	}
+
+	cHandler, eHandler, err := j.recordHandlers(block.flags)
+	if err != nil {
+		return nil, err
+	}
+
+	// decoded as if it were a file, so it goes through the same checks
+	reader := &JPkgFile{
+		pkg:            j,
+		path:           fmt.Sprintf("solid block at %v", block.offset),
+		size:           int64(block.size),
+		data:           data,
+		chunks:         chunks,
+		chunkIdx:       -1,
+		cHandler:       cHandler,
+		eHandler:       eHandler,
+		recordIndex:    -1,
+		associatedData: block.associatedData,
+	}
+
+	// grows as chunks are decoded, rather than trusting the block's size up front
+	decoded := bytes.Buffer{}
+	if _, err := io.Copy(&decoded, io.NewSectionReader(reader, 0, reader.size)); err != nil {
+		return nil, err
+	}
+
+	return decoded.Bytes(), nil
+}
+
+func (j *JPkg) openSolidFile(fileInfo jpkgFileOpenerInfo) (*JPkgFile, error) {
+	block, exists := j.solidBlocks[fileInfo.offset]
+	if !exists {
+		return nil, newCorrupt(fileInfo.offset, int64(fileInfo.index), "no solid block at %v", fileInfo.offset)
+	}
+
+	decoded, err := j.blockCache.get(block.offset, func() ([]byte, error) {
+		return j.decodeSolidBlock(block)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error opening %v: %w", fileInfo.path, err)
+	}
+
+	var verifier hash.Hash
+	if len(fileInfo.digest) != 0 {
+		verifier = j.hHandler.New()
+	}
+
+	cHandler, eHandler, err := j.recordHandlers(block.flags)
+	if err != nil {
+		return nil, fmt.Errorf("error opening %v: %w", fileInfo.path, err)
+	}
+
+	// the whole file is a single chunk, which is already decoded
+	return &JPkgFile{
+		pkg:        j,
+		name:       fileInfo.name,
+		size:       int64(fileInfo.uncompressedSize),
+		path:       fileInfo.path,
+		identifier: fileInfo.identifier,
+		uuid:       fileInfo.uuid,
+		metadata:   fileInfo.metadata,
+		chunks: jpkgChunkTable{
+			chunkSize: max(fileInfo.uncompressedSize, 1),
+			offsets:   []uint64{0, fileInfo.uncompressedSize},
+		},
+		chunkIdx: 0,
+		chunk:    decoded[fileInfo.blockOffset : fileInfo.blockOffset+fileInfo.uncompressedSize],
+		digest:   fileInfo.digest,
+		verifier: verifier,
+		cHandler: cHandler,
+		eHandler: eHandler,
+
+		recordIndex: int64(fileInfo.index),
+	}, nil
+}
+
+// the most recently used decoded solid blocks, so files in the same block share one decode
+type jpkgBlockCache struct {
+	lock     sync.Mutex
+	capacity int
+	// least recently used first
+	blocks []jpkgCachedBlock
+	// blocks being decoded, so other readers of the same block wait for it instead of
+	// decoding it again
+	pending map[int64]*jpkgBlockDecode
+}
+
+type jpkgCachedBlock struct {
+	offset int64
+	data   []byte
+}
+
+type jpkgBlockDecode struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+func (c *jpkgBlockCache) get(offset int64, decode func() ([]byte, error)) ([]byte, error) {
+	c.lock.Lock()
+
+	for i, block := range c.blocks {
+		if block.offset == offset {
+			c.blocks = append(append(c.blocks[:i], c.blocks[i+1:]...), block)
+			c.lock.Unlock()
+			return block.data, nil
+		}
+	}
+
+	if pending, ok := c.pending[offset]; ok {
+		c.lock.Unlock()
+		<-pending.done
+		return pending.data, pending.err
+	}
+
+	if c.pending == nil {
+		c.pending = map[int64]*jpkgBlockDecode{}
+	}
+	pending := &jpkgBlockDecode{done: make(chan struct{})}
+	c.pending[offset] = pending
+	c.lock.Unlock()
+
+	// decoding happens without the lock, so reads from other blocks aren't held up
+	pending.data, pending.err = decode()
+
+	c.lock.Lock()
+	delete(c.pending, offset)
+	if pending.err == nil {
+		if len(c.blocks) >= c.capacity {
+			c.blocks = c.blocks[1:]
+		}
+		c.blocks = append(c.blocks, jpkgCachedBlock{offset, pending.data})
+	}
+	c.lock.Unlock()
+	close(pending.done)
+
+	return pending.data, pending.err
+}
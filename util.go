@@ -7,6 +7,7 @@ import (
 	"io"
 	"path/filepath"
 	"strings"
+	"sync"
 )
 
 type UUID [16]byte
@@ -35,12 +36,64 @@ func serializeMetadataToJSON(data any) (string, error) {
 
 const MAGIC_NUMBER = uint32(0x6A706B67)
 
-func min(a, b int) int {
-	if a > b {
-		return b
-	}
-	return a
-}
+// packages are written as FORMAT_VERSION, version 0 packages can still be read
+const (
+	FORMAT_VERSION_0 = uint64(0)
+	FORMAT_VERSION   = uint64(1)
+)
+
+// from version 1, the header, encryption parameters and record data are padded to this
+const PADDING_ALIGNMENT = 16
+
+// "jdir", marks the fixed size trailer that points to the central directory
+const TRAILER_MAGIC_NUMBER = uint32(0x6A646972)
+
+const TRAILER_SIZE = 4 + 8 + 8 + 8
+
+// record data is split into independently compressed and encrypted chunks, with a chunk table at the end
+const TRAILER_FLAG_CHUNKED_RECORDS = uint64(1 << 0)
+
+// the manifest and central directory are compressed and encrypted, and records have no inline headers
+const TRAILER_FLAG_ENCRYPTED_INDEX = uint64(1 << 1)
+
+// directory entries end with the record's own compression and encryption flags
+const TRAILER_FLAG_RECORD_FLAGS = uint64(1 << 2)
+
+// directory entries are followed by a JPkgSolidBlockReference
+const TRAILER_FLAG_SOLID_BLOCKS = uint64(1 << 3)
+
+// block offset of records that aren't in a solid block
+const NOT_IN_SOLID_BLOCK = ^uint64(0)
+
+// the start of the central directory is a JPkgDictionary
+const TRAILER_FLAG_DICTIONARY = uint64(1 << 4)
+
+// bytes sampled from the start of each file to train a dictionary
+const DICTIONARY_SAMPLE_SIZE = uint64(16 * 1024)
+
+// samples stop once there's this many times the dictionary size
+const DICTIONARY_SAMPLE_RATIO = 100
+
+// decoded solid blocks kept by a reader, unless ReaderOptions says otherwise
+const DEFAULT_SOLID_BLOCK_CACHE = 4
+
+// reader limits used when ReaderOptions leaves them at 0
+const (
+	DEFAULT_MAX_STRING_LENGTH = 64 * 1024
+	DEFAULT_MAX_METADATA_SIZE = 1024 * 1024
+	DEFAULT_MAX_FILE_COUNT    = 1 << 24
+	// 1TiB
+	DEFAULT_MAX_UNCOMPRESSED_SIZE = 1 << 40
+	DEFAULT_MAX_COMPRESSION_RATIO = 1 << 16
+)
+
+const DEFAULT_CHUNK_SIZE = uint64(64 * 1024)
+
+// chunks are held in memory while they're encoded and decoded, 1GiB
+const MAX_CHUNK_SIZE = uint64(1 << 30)
+
+// written in place of record sizes that were not known before the data was streamed
+const UNKNOWN_SIZE = ^uint64(0)
 
 func normalizeFilePath(name string) string {
 	name = strings.TrimPrefix(name, ".")
@@ -53,3 +106,34 @@ func normalizeFilePath(name string) string {
 	name = strings.ReplaceAll(name, "/", "\\")
 	return name
 }
+
+type countingWriter struct {
+	w     io.Writer
+	count uint64
+}
+
+func (c *countingWriter) Write(p []byte) (n int, err error) {
+	n, err = c.w.Write(p)
+	c.count += uint64(n)
+	return
+}
+
+type lockedReaderAt struct {
+	lock sync.Mutex
+	r    io.ReadSeeker
+}
+
+func (l *lockedReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if _, err := l.r.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	n, err := io.ReadFull(l.r, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
@@ -0,0 +1,81 @@
+package jpkg
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	jpkg_bin "github.com/j4d3blooded/JPkg/bin"
+	jpkg_impl "github.com/j4d3blooded/JPkg/impl"
+)
+
+// padding cycles through these, starting from the first byte of the padding
+var paddingPattern = [...]byte{0xDE, 0xAD, 0xBE, 0xEF}
+
+// version 0 stores strings as runes, later versions as UTF-8
+func (h *JPkgHeader) strings() jpkg_bin.StringEncoding {
+	if h.Version == FORMAT_VERSION_0 {
+		return jpkg_bin.STRING_RUNES
+	}
+	return jpkg_bin.STRING_UTF8
+}
+
+// version 0 has no padding, later versions pad so the package body and the data of every
+// record start on PADDING_ALIGNMENT
+func (h *JPkgHeader) padded() bool {
+	return h.Version != FORMAT_VERSION_0
+}
+
+// version 0 has no encryption parameters for AES with a raw key, so wrong keys are only
+// caught when decryption fails
+func (h *JPkgHeader) aesKeyChecked() bool {
+	return h.Version != FORMAT_VERSION_0 || h.EncryptionFlag != jpkg_impl.ENCRYPTION_AES
+}
+
+// always at least one byte, so an aligned offset gets a whole PADDING_ALIGNMENT of padding
+func paddingLength(offset uint64) uint64 {
+	return PADDING_ALIGNMENT - offset%PADDING_ALIGNMENT
+}
+
+func padding(offset uint64) []byte {
+	pad := make([]byte, paddingLength(offset))
+	for i := range pad {
+		pad[i] = paddingPattern[i%len(paddingPattern)]
+	}
+	return pad
+}
+
+func (j *JPkgEncoder) writePadding() error {
+	if !j.header.padded() {
+		return nil
+	}
+
+	if _, err := j.w.Write(padding(j.w.count)); err != nil {
+		return fmt.Errorf("error writing padding: %w", err)
+	}
+	return nil
+}
+
+// skips the padding at the current position of r, which has to match what's written
+func skipPadding(r io.ReadSeeker, header *JPkgHeader) error {
+	if !header.padded() {
+		return nil
+	}
+
+	position, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fmt.Errorf("error seeking in file: %w", err)
+	}
+
+	expected := padding(uint64(position))
+	pad := make([]byte, len(expected))
+	if _, err := io.ReadFull(r, pad); err != nil {
+		return asCorrupt(position, -1, err)
+	}
+
+	if !bytes.Equal(pad, expected) {
+		return newCorrupt(position, -1, "padding is %x, not %x", pad, expected)
+	}
+
+	return nil
+}
@@ -2,12 +2,13 @@ package jpkg
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
-	"path/filepath"
-	"strings"
 	"time"
 
 	jpkg_bin "github.com/j4d3blooded/JPkg/bin"
@@ -16,11 +17,12 @@ import (
 
 func NewJPkgEncoder(w io.Writer) *JPkgEncoder {
 	wr := &JPkgEncoder{
-		w:           w,
+		w:           &countingWriter{w: w},
 		Encryption:  &jpkg_impl.NullEncryptionHandler{},
 		Compression: &jpkg_impl.NullCompressionHandler{},
 		Hasher:      &jpkg_impl.NullHasherHandler{},
 		Signer:      &jpkg_impl.NullCryptoHandler{},
+		ChunkSize:   DEFAULT_CHUNK_SIZE,
 		PackageTime: time.Now(),
 		Metadata:    nil,
 	}
@@ -35,9 +37,42 @@ type JPkgEncoder struct {
 	Compression jpkg_impl.CompressionHandler
 	Hasher      jpkg_impl.HasherHandler
 	Signer      jpkg_impl.CryptoHandler
-	w           io.Writer
-	files       []jpkgFileRecord
-	offset      uint64
+	ChunkSize   uint64
+	// compresses and encrypts the manifest and central directory, so without the key
+	// only the header can be read. needs an encryption handler
+	EncryptIndex bool
+	// compresses each file with whichever of Compression and CompressionCandidates
+	// shrinks a sample of it the most, or stores it uncompressed if none of them do.
+	// files with their own compression aren't sampled
+	Adaptive              bool
+	CompressionCandidates []jpkg_impl.CompressionHandler
+	// bytes from the start of each file to sample, 0 samples the first chunk
+	AdaptiveSampleSize uint64
+	// if set, consecutive files no larger than this are concatenated into shared blocks
+	// of up to this size, each compressed and encrypted as a single chunk. readers
+	// decode a whole block to read any file in it
+	SolidBlockSize uint64
+	// a dictionary stored in the package and used by every file compressed with Compression,
+	// which must be a jpkg_impl.DictionaryCompressionHandler. if DictionarySize is set and
+	// there's no dictionary, one of about that size is trained from the start of every file
+	Dictionary     []byte
+	DictionarySize uint64
+	// goroutines compressing and encrypting chunks, 0 uses one per CPU. records are still
+	// written in order, so the output doesn't depend on it. handlers have to be safe to
+	// use from several goroutines at once
+	Workers   int
+	w         *countingWriter
+	files     []jpkgFileRecord
+	directory []JPkgFileRecordWithOffset
+	header    JPkgHeader
+	manifest  JPkgManifest
+	context   []byte
+	// the block being filled by small files, and their directory entries
+	solidBlock   bytes.Buffer
+	solidMembers []JPkgFileRecordWithOffset
+	// hashes of everything written, for the footer
+	integrityHash hash.Hash
+	signatureHash hash.Hash
 }
 
 type JPkgFileToEncode struct {
@@ -46,6 +81,12 @@ type JPkgFileToEncode struct {
 	Identifier string
 	Path       string
 	Metadata   any
+	// overrides the package compression for this file if set
+	Compression jpkg_impl.CompressionHandler
+	// overrides the package encryption for this file if set. there is only one key per
+	// package, so this can only be a NullEncryptionHandler to store the file unencrypted,
+	// or a handler with the same flag as the package's, which is then used in its place
+	Encryption jpkg_impl.EncryptionHandler
 }
 
 type jpkgFileRecord struct {
@@ -54,6 +95,8 @@ type jpkgFileRecord struct {
 	path         string
 	uuid         UUID
 	metadataJson string
+	compression  jpkg_impl.CompressionHandler
+	encryption   jpkg_impl.EncryptionHandler
 }
 
 func (j *JPkgEncoder) AddFile(file JPkgFileToEncode) error {
@@ -67,17 +110,11 @@ func (j *JPkgEncoder) AddFile(file JPkgFileToEncode) error {
 		return fmt.Errorf("error serializing json metadata: %w", err)
 	}
 
-	file.Path = strings.TrimPrefix(file.Path, ".")
-
-	if !strings.HasPrefix(file.Path, "/") {
-		file.Path = "/" + file.Path
-	}
-
-	file.Path = filepath.Clean(file.Path)
+	file.Path = normalizeFilePath(file.Path)
 
 	for _, existingFile := range j.files {
 		if existingFile.path == file.Path {
-			return errors.New("path is already in use")
+			return fmt.Errorf("%w: %v", ErrDuplicatePath, file.Path)
 		}
 	}
 
@@ -87,6 +124,8 @@ func (j *JPkgEncoder) AddFile(file JPkgFileToEncode) error {
 		identifier:   file.Identifier,
 		metadataJson: json,
 		path:         file.Path,
+		compression:  file.Compression,
+		encryption:   file.Encryption,
 	}
 
 	j.files = append(j.files, nf)
@@ -95,32 +134,116 @@ func (j *JPkgEncoder) AddFile(file JPkgFileToEncode) error {
 }
 
 func (j *JPkgEncoder) Encode() error {
+	return j.EncodeContext(context.Background())
+}
+
+// EncodeContext is Encode, stopping early with the context's error if it's cancelled
+func (j *JPkgEncoder) EncodeContext(ctx context.Context) error {
+	if j.EncryptIndex && j.Encryption.Flag() == jpkg_impl.ENCRYPTION_NONE {
+		return errors.New("encrypting the index needs an encryption handler")
+	}
+
+	if j.ChunkSize == 0 || j.ChunkSize > MAX_CHUNK_SIZE {
+		return fmt.Errorf("chunk size %v is not between 1 and %v", j.ChunkSize, MAX_CHUNK_SIZE)
+	}
+
+	j.hashOutput()
+
 	if err := j.writeHeader(); err != nil {
 		return fmt.Errorf("error writing header: %w", err)
 	}
 
+	if err := j.writeEncryptionParameters(); err != nil {
+		return fmt.Errorf("error writing encryption parameters: %w", err)
+	}
+
 	if err := j.writeManifest(); err != nil {
 		return fmt.Errorf("error writing manifest: %w", err)
 	}
 
-	if err := j.writeFileRecords(); err != nil {
+	if err := j.prepareDictionary(); err != nil {
+		return fmt.Errorf("error preparing dictionary: %w", err)
+	}
+
+	if err := j.writeFileRecords(ctx); err != nil {
 		return fmt.Errorf("error writing file records: %w", err)
 	}
 
+	flags := TRAILER_FLAG_CHUNKED_RECORDS | TRAILER_FLAG_RECORD_FLAGS
+	if j.EncryptIndex {
+		flags |= TRAILER_FLAG_ENCRYPTED_INDEX
+	}
+	if j.SolidBlockSize != 0 {
+		flags |= TRAILER_FLAG_SOLID_BLOCKS
+	}
+	if j.Dictionary != nil {
+		flags |= TRAILER_FLAG_DICTIONARY
+	}
+
+	if err := j.writeDirectory(flags); err != nil {
+		return fmt.Errorf("error writing central directory: %w", err)
+	}
+
+	if err := j.writeFooter(); err != nil {
+		return fmt.Errorf("error writing footer: %w", err)
+	}
+
 	return nil
 }
 
+// hashing the output means it can no longer be seeked, so record sizes will only
+// be written to the central directory
+func (j *JPkgEncoder) hashOutput() {
+	j.integrityHash = j.Hasher.New()
+	if j.integrityHash != nil {
+		j.w.w = io.MultiWriter(j.w.w, j.integrityHash)
+	}
+
+	j.signatureHash = j.Signer.NewHash()
+	if j.signatureHash != nil {
+		j.w.w = io.MultiWriter(j.w.w, j.signatureHash)
+	}
+}
+
 func (j *JPkgEncoder) writeHeader() error {
 	header := JPkgHeader{
 		MagicNumber:     MAGIC_NUMBER,
-		Version:         0,
+		Version:         FORMAT_VERSION,
 		CompressionFlag: j.Compression.Flag(),
 		EncryptionFlag:  j.Encryption.Flag(),
 		HasherFlag:      j.Hasher.Flag(),
 		SignatureFlag:   j.Signer.Flag(),
 	}
 
-	return jpkg_bin.BinaryWrite(j.w, header)
+	j.header = header
+	if err := jpkg_bin.BinaryWrite(j.w, header); err != nil {
+		return err
+	}
+
+	return j.writePadding()
+}
+
+func (j *JPkgEncoder) writeEncryptionParameters() error {
+	handler, isParameterized := j.Encryption.(jpkg_impl.ParameterizedEncryptionHandler)
+	if !isParameterized {
+		return nil
+	}
+
+	parameters, err := handler.Parameters()
+	if err != nil {
+		return fmt.Errorf("error generating encryption parameters: %w", err)
+	}
+
+	if err := jpkg_bin.BinaryWrite(j.w, JPkgEncryptionParameters{parameters}); err != nil {
+		return err
+	}
+
+	return j.writePadding()
+}
+
+// strings in the encoding of the package version
+func (j *JPkgEncoder) write(w io.Writer, data any) error {
+	return jpkg_bin.BinaryWriteEncoded(w, data, j.header.strings())
 }
 
 func (j *JPkgEncoder) writeManifest() error {
@@ -137,51 +260,428 @@ func (j *JPkgEncoder) writeManifest() error {
 		PackageMetadataJSON: string(metadataJson),
 	}
 
-	if err := jpkg_bin.BinaryWrite(j.w, manifest); err != nil {
-		return fmt.Errorf("error writing package manifest: %w", err)
+	j.manifest = manifest
+
+	if !j.EncryptIndex { // otherwise it's written with the central directory
+		if err := j.write(j.w, manifest); err != nil {
+			return fmt.Errorf("error writing package manifest: %w", err)
+		}
 	}
 
-	return nil
+	j.context, err = packageContext(j.header, manifest)
+	return err
+}
+
+func (j *JPkgEncoder) writeFileRecords(ctx context.Context) error {
+	p := j.startPipeline(ctx)
+	return p.finish(j.queueFileRecords(p))
 }
 
-func (j *JPkgEncoder) writeFileRecords() error {
-	for _, file := range j.files {
+// reads every file in order, queueing its chunks to be encoded and written
+func (j *JPkgEncoder) queueFileRecords(p *jpkgPipeline) error {
+	for i, file := range j.files {
+		name := fmt.Sprintf("file (%v/%v/%v)", file.path, file.identifier, file.uuid)
+
+		if j.SolidBlockSize != 0 && file.compression == nil && file.encryption == nil {
+			isSmall, source, err := j.addToSolidBlock(p, file, uint64(i))
+			if err != nil {
+				return fmt.Errorf("error writing %v: %w", name, err)
+			}
+			if isSmall {
+				continue
+			}
+			file.source = source
+		}
+
+		// records in a block stay in order with the records around them
+		if err := j.flushSolidBlock(p); err != nil {
+			return err
+		}
+
+		if j.Adaptive && file.compression == nil {
+			compression, source, err := j.chooseCompression(file.source)
+			if err != nil {
+				return fmt.Errorf("error sampling %v: %w", name, err)
+			}
+			file.compression, file.source = compression, source
+		}
 
-		uncompressedBytes, err := io.ReadAll(file.source)
+		compression, encryption, err := j.recordHandlers(file)
 		if err != nil {
-			return fmt.Errorf("error reading file (%v/%v/%v): %w", file.path, file.identifier, file.uuid, err)
+			return fmt.Errorf("error writing %v: %w", name, err)
 		}
 
-		uncompressedSize := len(uncompressedBytes)
-		compressed, err := j.Compression.Compress(uncompressedBytes)
+		record := JPkgFileRecordWithoutData{
+			FileIdentifier:       file.identifier,
+			FilePath:             file.path,
+			UUID:                 file.uuid,
+			FileMetadataJSON:     file.metadataJson,
+			CompressedDataSize:   UNKNOWN_SIZE,
+			UncompressedDataSize: UNKNOWN_SIZE,
+		}
+
+		// where the record sizes end, as there may be padding before the data
+		sizesEnd := uint64(0)
+
+		if !j.EncryptIndex {
+			err := p.queueWrite(func() error {
+				if err := j.write(j.w, record); err != nil {
+					return fmt.Errorf("error writing %v: %w", name, err)
+				}
+				sizesEnd = j.w.count
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		associatedData, err := recordAssociatedData(jpkgRecordIdentity{
+			Context:    j.context,
+			Index:      uint64(i),
+			UUID:       file.uuid,
+			Path:       file.path,
+			Identifier: file.identifier,
+			Metadata:   file.metadataJson,
+		}, j.header.strings())
 		if err != nil {
-			return fmt.Errorf("error compressing file (%v/%v/%v): %w", file.path, file.identifier, file.uuid, err)
+			return err
 		}
 
-		encrypted := bytes.Buffer{}
-		encryptor, err := j.Encryption.Encrypt(&encrypted)
+		err = j.queueRecordData(
+			p, name, file.source, associatedData, j.ChunkSize, compression, encryption,
+			func(offset, uncompressedSize uint64, digest []byte) error {
+				record.CompressedDataSize = j.w.count - offset
+				record.UncompressedDataSize = uncompressedSize
+
+				if !j.EncryptIndex {
+					if err := j.patchRecordSizes(record, sizesEnd); err != nil {
+						return fmt.Errorf("error writing sizes of %v: %w", name, err)
+					}
+				}
+
+				j.directory = append(j.directory, JPkgFileRecordWithOffset{
+					JPkgFileRecordWithoutData: record,
+					Offset:                    offset,
+					Digest:                    digest,
+					JPkgRecordFlags:           JPkgRecordFlags{compression.Flag(), encryption.Flag()},
+				})
+				return nil
+			},
+		)
 		if err != nil {
-			return fmt.Errorf("error creating encryptor: %w", err)
+			return fmt.Errorf("error writing %v: %w", name, err)
 		}
-		if _, err := encryptor.Write(compressed); err != nil {
-			return fmt.Errorf("error encrypting file (%v/%v/%v): %w", file.path, file.identifier, file.uuid, err)
+	}
+
+	return j.flushSolidBlock(p)
+}
+
+// the package handlers, unless the file overrides them
+func (j *JPkgEncoder) recordHandlers(file jpkgFileRecord) (jpkg_impl.CompressionHandler, jpkg_impl.EncryptionHandler, error) {
+	compression, encryption := j.Compression, j.Encryption
+
+	if file.compression != nil {
+		compression = file.compression
+	}
+
+	compression, err := j.withDictionary(compression)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if file.encryption != nil {
+		switch file.encryption.Flag() {
+		case jpkg_impl.ENCRYPTION_NONE:
+			encryption = file.encryption
+		case j.Encryption.Flag():
+		default:
+			return nil, nil, fmt.Errorf("file encryption %v doesn't match the package encryption %v", file.encryption.Flag(), j.Encryption.Flag())
 		}
+	}
 
-		record := _JPkgFileRecord{
-			JPkgFileRecordWithoutData: JPkgFileRecordWithoutData{
-				FileIdentifier:       file.identifier,
-				FilePath:             file.path,
-				UUID:                 file.uuid,
-				FileMetadataJSON:     file.metadataJson,
-				CompressedDataSize:   uint64(encrypted.Len()),
-				UncompressedDataSize: uint64(uncompressedSize),
-			},
-			CompressedData: encrypted.Bytes(),
+	return compression, encryption, nil
+}
+
+// splits the source into chunkSize pieces which are each compressed then encrypted
+// on their own, so readers only need to decode the chunks they touch. the compressed
+// size of every chunk is written after the last one, followed by the chunk size and
+// count. the data is padded to start on PADDING_ALIGNMENT from version 1. once the
+// record is written, written is called on the writer goroutine with
+// the record's data offset, its uncompressed size and, if the package is hashed, the
+// digest of the uncompressed data
+func (j *JPkgEncoder) queueRecordData(
+	p *jpkgPipeline, name string, source io.Reader, associatedData []byte, chunkSize uint64,
+	compression jpkg_impl.CompressionHandler, encryption jpkg_impl.EncryptionHandler,
+	written func(offset, uncompressedSize uint64, digest []byte) error,
+) error {
+	offset := uint64(0)
+	chunkSizes := []uint64{}
+	uncompressedSize := uint64(0)
+	digest := j.Hasher.New()
+
+	err := p.queueWrite(func() error {
+		if err := j.writePadding(); err != nil {
+			return fmt.Errorf("error writing %v: %w", name, err)
+		}
+		offset = j.w.count
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// a chunk ahead is read, to know which chunk is the last one
+	next, err := readSourceChunk(source, chunkSize)
+	if err != nil {
+		return err
+	}
+
+	for index := 0; ; index++ {
+		chunk := next
+
+		last := uint64(len(chunk)) < chunkSize
+		if !last {
+			next, err = readSourceChunk(source, chunkSize)
+			if err != nil {
+				return err
+			}
+			last = len(next) == 0
+		}
+
+		uncompressedSize += uint64(len(chunk))
+		if digest != nil {
+			digest.Write(chunk)
 		}
 
-		if err := jpkg_bin.BinaryWrite(j.w, record); err != nil {
-			return fmt.Errorf("error writing file (%v/%v/%v): %w", file.path, file.identifier, file.uuid, err)
+		job := &jpkgChunkJob{
+			record:         name,
+			index:          index,
+			data:           chunk,
+			associatedData: chunkAssociatedData(associatedData, index, last),
+			compression:    compression,
+			encryption:     encryption,
 		}
+
+		err = p.queueChunk(job, func(output []byte) error {
+			if _, err := j.w.Write(output); err != nil {
+				return fmt.Errorf("error writing %v: error writing chunk %v: %w", name, index, err)
+			}
+			chunkSizes = append(chunkSizes, uint64(len(output)))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if last {
+			break
+		}
+	}
+
+	var sum []byte
+	if digest != nil {
+		sum = digest.Sum(nil)
+	}
+
+	return p.queueWrite(func() error {
+		// records smaller than a chunk store their own size, chunks are never larger than the record
+		table := append(chunkSizes, min(chunkSize, max(uncompressedSize, 1)), uint64(len(chunkSizes)))
+		if err := binary.Write(j.w, binary.BigEndian, table); err != nil {
+			return fmt.Errorf("error writing %v: error writing chunk table: %w", name, err)
+		}
+
+		return written(offset, uncompressedSize, sum)
+	})
+}
+
+// reads chunkSize bytes, fewer only at the end of the source. the buffer grows as data
+// is read, so small files don't allocate a whole chunk
+func readSourceChunk(source io.Reader, chunkSize uint64) ([]byte, error) {
+	chunk := bytes.Buffer{}
+	if _, err := io.CopyN(&chunk, source, int64(chunkSize)); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("error reading file data: %w", err)
+	}
+	return chunk.Bytes(), nil
+}
+
+func compressChunk(compression jpkg_impl.CompressionHandler, w io.Writer, chunk []byte) error {
+	compressor, err := compression.CompressWriter(w)
+	if err != nil {
+		return fmt.Errorf("error creating compressor: %w", err)
+	}
+
+	if _, err := compressor.Write(chunk); err != nil {
+		return fmt.Errorf("error compressing data: %w", err)
+	}
+
+	if err := compressor.Close(); err != nil {
+		return fmt.Errorf("error closing compressor: %w", err)
+	}
+
+	return nil
+}
+
+func encryptChunk(encryption jpkg_impl.EncryptionHandler, w io.Writer, chunk []byte, associatedData []byte) error {
+	encryptor, err := encryption.Encrypt(w, associatedData)
+	if err != nil {
+		return fmt.Errorf("error creating encryptor: %w", err)
+	}
+
+	if _, err := encryptor.Write(chunk); err != nil {
+		return fmt.Errorf("error encrypting data: %w", err)
+	}
+
+	if err := encryptor.Close(); err != nil {
+		return fmt.Errorf("error closing encryptor: %w", err)
+	}
+
+	return nil
+}
+
+// the record header is written before its sizes are known, if the output can seek
+// they are written in place, otherwise they're left as UNKNOWN_SIZE and only the
+// central directory has them
+func (j *JPkgEncoder) patchRecordSizes(record JPkgFileRecordWithoutData, sizesEnd uint64) error {
+	ws, isSeeker := j.w.w.(io.WriteSeeker)
+	if !isSeeker {
+		return nil
+	}
+
+	distance := int64(j.w.count - sizesEnd + 16)
+
+	if _, err := ws.Seek(-distance, io.SeekCurrent); err != nil {
+		return fmt.Errorf("error seeking to record sizes: %w", err)
+	}
+
+	sizes := []uint64{record.CompressedDataSize, record.UncompressedDataSize}
+	if err := binary.Write(ws, binary.BigEndian, sizes); err != nil {
+		return fmt.Errorf("error writing record sizes: %w", err)
+	}
+
+	if _, err := ws.Seek(distance-16, io.SeekCurrent); err != nil {
+		return fmt.Errorf("error seeking to end of record: %w", err)
+	}
+
+	return nil
+}
+
+// the central directory repeats every record header along with its data offset,
+// so readers can load the whole index with a single read from the trailer
+func (j *JPkgEncoder) writeDirectory(flags uint64) error {
+	directoryOffset := j.w.count
+
+	if flags&TRAILER_FLAG_ENCRYPTED_INDEX != 0 {
+		if err := j.writeEncryptedIndex(flags); err != nil {
+			return err
+		}
+	} else {
+		if err := writeDirectoryDictionary(j.w, j.Dictionary, flags); err != nil {
+			return fmt.Errorf("error writing dictionary: %w", err)
+		}
+
+		for _, entry := range j.directory {
+			if err := j.writeDirectoryEntry(j.w, entry, flags); err != nil {
+				return fmt.Errorf("error writing directory entry (%v): %w", entry.FilePath, err)
+			}
+		}
+	}
+
+	trailer := JPkgTrailer{
+		MagicNumber:     TRAILER_MAGIC_NUMBER,
+		DirectoryOffset: directoryOffset,
+		DirectorySize:   j.w.count - directoryOffset,
+		Flags:           flags,
+	}
+
+	if err := jpkg_bin.BinaryWrite(j.w, trailer); err != nil {
+		return fmt.Errorf("error writing trailer: %w", err)
+	}
+
+	return nil
+}
+
+func writeDirectoryDictionary(w io.Writer, dictionary []byte, flags uint64) error {
+	if flags&TRAILER_FLAG_DICTIONARY == 0 {
+		return nil
+	}
+	return jpkg_bin.BinaryWrite(w, JPkgDictionary{dictionary})
+}
+
+func (j *JPkgEncoder) writeDirectoryEntry(w io.Writer, entry JPkgFileRecordWithOffset, flags uint64) error {
+	if err := j.write(w, entry); err != nil {
+		return err
+	}
+
+	if flags&TRAILER_FLAG_SOLID_BLOCKS == 0 {
+		return nil
+	}
+
+	reference := JPkgSolidBlockReference{BlockOffset: NOT_IN_SOLID_BLOCK}
+	if entry.block != nil {
+		reference = *entry.block
+	}
+
+	return jpkg_bin.BinaryWrite(w, reference)
+}
+
+// the manifest and directory entries, compressed and encrypted as a single chunk
+func (j *JPkgEncoder) writeEncryptedIndex(flags uint64) error {
+	index := bytes.Buffer{}
+
+	if err := j.write(&index, j.manifest); err != nil {
+		return fmt.Errorf("error writing package manifest: %w", err)
+	}
+
+	if err := writeDirectoryDictionary(&index, j.Dictionary, flags); err != nil {
+		return fmt.Errorf("error writing dictionary: %w", err)
+	}
+
+	for _, entry := range j.directory {
+		if err := j.writeDirectoryEntry(&index, entry, flags); err != nil {
+			return fmt.Errorf("error writing directory entry (%v): %w", entry.FilePath, err)
+		}
+	}
+
+	compressed := bytes.Buffer{}
+	if err := compressChunk(j.Compression, &compressed, index.Bytes()); err != nil {
+		return fmt.Errorf("error compressing index: %w", err)
+	}
+
+	associatedData, err := indexAssociatedData(j.header)
+	if err != nil {
+		return err
+	}
+
+	if err := encryptChunk(j.Encryption, j.w, compressed.Bytes(), associatedData); err != nil {
+		return fmt.Errorf("error encrypting index: %w", err)
+	}
+
+	return nil
+}
+
+func (j *JPkgEncoder) writeFooter() error {
+	if j.integrityHash != nil {
+		if _, err := j.w.Write(j.integrityHash.Sum(nil)); err != nil {
+			return fmt.Errorf("error writing package hash: %w", err)
+		}
+	}
+
+	if j.signatureHash == nil {
+		return nil
+	}
+
+	signature, err := j.Signer.Sign(j.signatureHash.Sum(nil))
+	if err != nil {
+		return fmt.Errorf("error signing package: %w", err)
+	}
+
+	if len(signature) != j.Signer.SignatureSize() {
+		return fmt.Errorf("signature has incorrect size: %v", len(signature))
+	}
+
+	if _, err := j.w.Write(signature); err != nil {
+		return fmt.Errorf("error writing signature: %w", err)
 	}
 
 	return nil